@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -16,23 +17,47 @@ import (
 
 var (
 	testName        = flag.String("test_name", "unnamed_test", "Sets the test name to use")
-	reorderDuration = flag.Bool("reorder_duration", false, "If set, will reorder durations to work around https://github.com/bats-core/bats-core/issues/187")
+	reorderDuration = flag.Bool("reorder_duration", false, "Deprecated: use -dialect=bats.  If set, will reorder durations to work around https://github.com/bats-core/bats-core/issues/187")
 	reorderAll      = flag.Bool("reorder_all", false, "If set, will reorder all test lines to work around https://github.com/bats-core/bats-core/issues/187")
 	singleSuite     = flag.Bool("single_suite", false, "If set, will output only the <testsuite> as top-level tag; not <testsuites>")
+	strict          = flag.Bool("strict", false, "If set, will fail on the first problem with the TAP input, instead of warning about it")
+	maxLineLength   = flag.Int("max_line_length", 0, "If set, input lines longer than this many bytes will be truncated; otherwise lines can be of any length")
+	timeout         = flag.Duration("timeout", 0, "If set, will stop reading the TAP input after this long, and write a report of the tests read so far, flagged as incomplete")
+	inferDurations  = flag.Bool("infer_durations", false, "If set, will time the tests of a live input as they are read, for the tests without a duration annotation; also sets the suite timestamp")
+
+	convertOpt tojunit.ConvertOpt
+	dialect    = tap.Generic
 )
 
-func run(r io.Reader, w io.Writer, opts tap.ReadOpt, singleSuite bool) error {
-	t, err := tap.Read(r, opts)
-	if err != nil {
-		return fmt.Errorf("while reading TAP: %v", err)
+func init() {
+	flag.Var(&convertOpt.Skip, "skip_as", "How to report SKIP tests: skipped, passed or failed")
+	flag.Var(&convertOpt.TodoFailed, "todo_failed_as", "How to report failed TODO tests: skipped, passed or failed")
+	flag.Var(&convertOpt.TodoPassed, "todo_passed_as", "How to report passed TODO tests: skipped, passed or failed")
+	flag.BoolVar(&convertOpt.Positions, "positions", false, "If set, will add the input lines and byte offsets of each test as properties of the testcase")
+	flag.Var(&dialect, "dialect", "The producer of the TAP input, whose quirks to work around: generic, bats, node-tap, perl, pytest, or auto to detect it from the input")
+}
+
+func run(ctx context.Context, r io.Reader, w io.Writer, opts tap.ReadOpt, copts tojunit.ConvertOpt, singleSuite bool) error {
+	ts, readErr := tap.ReadAllContext(ctx, r, opts)
+	if readErr != nil && ctx.Err() == nil {
+		return fmt.Errorf("while reading TAP: %v", readErr)
 	}
-	j, err := tojunit.FromTAP(t)
+	for _, t := range ts {
+		for _, w := range t.Warnings {
+			glog.Warningf("while reading TAP %q: %v", t.Name, w)
+		}
+	}
+	j, err := tojunit.FromTAPAll(ts, copts)
 	if err != nil {
 		return fmt.Errorf("while converting to jUnit: %v", err)
 	}
 	if err := junit.Write(j, w, singleSuite); err != nil {
 		return fmt.Errorf("while writing jUnit: %v", err)
 	}
+	if readErr != nil {
+		// The report of what was read is written, but it is incomplete.
+		return fmt.Errorf("while reading TAP: %v", readErr)
+	}
 	return nil
 }
 
@@ -42,8 +67,20 @@ func main() {
 		Name:            *testName,
 		ReorderDuration: *reorderDuration,
 		ReorderAll:      *reorderAll,
+		Dialect:         dialect,
+		InferDurations:  *inferDurations,
+		Strict:          *strict,
+		MaxLineLength:   *maxLineLength,
+		// The conversion has no use for a copy of the whole input.
+		DiscardRaw: true,
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
 	}
-	if err := run(os.Stdin, os.Stdout, opts, *singleSuite); err != nil {
+	if err := run(ctx, os.Stdin, os.Stdout, opts, convertOpt, *singleSuite); err != nil {
 		glog.Fatalf("unexpected error: %v", err)
 	}
 }
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"io"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/filmil/tap2junit/pkg/tap"
+	"github.com/filmil/tap2junit/pkg/tap/tojunit"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -16,6 +20,7 @@ func TestCli(t *testing.T) {
 		expected    string
 		reorder     bool
 		reorderAll  bool
+		convertOpt  tojunit.ConvertOpt
 	}{
 		{
 			name: "Basic",
@@ -91,6 +96,50 @@ not ok 1 Hello
  1 Hello]]></failure>
          </testcase>
       </testsuite>
+   </testsuites>`,
+		},
+		{
+			name: "Crashed before the end of the plan",
+			input: `1..2
+ok 1 This test
+`,
+			expected: `<?xml version="1.0" encoding="UTF-8"?>
+   <testsuites tests="2" failures="0" errors="1" time="0.000">
+      <testsuite id="7cc84235ce3aaeab160cebf213fdff2a0d92dcb4e6304dee5fb2762673f107f1" name="named_test" tests="2" failures="0" errors="1" time="0.000">
+         <testcase id="d32c977c8ba0374c3c0e821206cc08d19a041daa9caec8c7373de9175b1189e8" name="This test" time="0.000"></testcase>
+         <testcase id="f82ac1eee910ca8baa743a325e59fabaceb2a36af9f8ee8c80f90ee32ee4fe23" name="test 2 (missing)" time="0.000">
+            <error message="test 2 was planned, but reported no result" type="MissingTest"></error>
+         </testcase>
+      </testsuite>
+   </testsuites>`,
+		},
+		{
+			name: "Description with separator and directive",
+			input: `1..1
+ok 1 - This test # SKIP not today
+`,
+			expected: `<?xml version="1.0" encoding="UTF-8"?>
+   <testsuites tests="1" failures="0" skipped="1" time="0.000">
+      <testsuite id="7cc84235ce3aaeab160cebf213fdff2a0d92dcb4e6304dee5fb2762673f107f1" name="named_test" tests="1" failures="0" skipped="1" time="0.000">
+         <testcase id="d32c977c8ba0374c3c0e821206cc08d19a041daa9caec8c7373de9175b1189e8" name="This test" time="0.000">
+            <skipped message="not today"></skipped>
+         </testcase>
+      </testsuite>
+   </testsuites>`,
+		},
+		{
+			name: "Program output",
+			input: `1..1
+ok 1 - This test
+hello from the test
+`,
+			expected: `<?xml version="1.0" encoding="UTF-8"?>
+   <testsuites tests="1" failures="0" time="0.000">
+      <testsuite id="7cc84235ce3aaeab160cebf213fdff2a0d92dcb4e6304dee5fb2762673f107f1" name="named_test" tests="1" failures="0" time="0.000">
+         <testcase id="d32c977c8ba0374c3c0e821206cc08d19a041daa9caec8c7373de9175b1189e8" name="This test" time="0.000">
+            <system-out><![CDATA[hello from the test]]></system-out>
+         </testcase>
+      </testsuite>
    </testsuites>`,
 		},
 	}
@@ -103,7 +152,7 @@ not ok 1 Hello
 				Name:       "named_test",
 				ReorderAll: test.reorder,
 			}
-			if err := run(strings.NewReader(test.input), &b, opts, test.singleSuite); err != nil {
+			if err := run(context.Background(), strings.NewReader(test.input), &b, opts, test.convertOpt, test.singleSuite); err != nil {
 				t.Fatal(err)
 			}
 			actual := strings.Split(b.String(), "\n")
@@ -115,3 +164,183 @@ not ok 1 Hello
 		})
 	}
 }
+
+func TestCliStrict(t *testing.T) {
+	input := `1..1
+not TAP
+ok 1 - fine
+`
+	var b strings.Builder
+	opts := tap.ReadOpt{Name: "named_test", Strict: true}
+	err := run(context.Background(), strings.NewReader(input), &b, opts, tojunit.ConvertOpt{}, false)
+	if err == nil {
+		t.Fatalf("expected an error, got output:\n%v", b.String())
+	}
+	const expected = `while reading TAP: line 2 (byte 5), column 1: not a TAP line: "not TAP"`
+	if err.Error() != expected {
+		t.Errorf("expected error: %q, got: %q", expected, err.Error())
+	}
+}
+
+func TestCliStrictRegion(t *testing.T) {
+	input := "1..1\npragma +strict\nnot tap\nok 1\n"
+	var b strings.Builder
+	opts := tap.ReadOpt{Name: "named_test"}
+	err := run(context.Background(), strings.NewReader(input), &b, opts, tojunit.ConvertOpt{}, false)
+	const expected = `while reading TAP: line 3 (byte 20), column 1: not a TAP line in a strict region: "not tap"`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error: %q, got: %v", expected, err)
+	}
+}
+
+func TestCliLongLines(t *testing.T) {
+	// Longer than the 64KiB that a bufio.Scanner accepts.
+	input := "1..1\n# " + strings.Repeat("x", 1<<17) + "\nok 1 - fine\n"
+	var b strings.Builder
+	opts := tap.ReadOpt{Name: "named_test", MaxLineLength: 1 << 10}
+	if err := run(context.Background(), strings.NewReader(input), &b, opts, tojunit.ConvertOpt{}, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(b.String(), `name="fine"`) {
+		t.Errorf("the test after the long line is missing:\n%v", b.String())
+	}
+}
+
+func TestCliDocuments(t *testing.T) {
+	input := `TAP version 13
+1..1
+ok 1 - first
+TAP version 13
+1..1
+not ok 1 - second
+`
+	var b strings.Builder
+	opts := tap.ReadOpt{Name: "named_test"}
+	if err := run(context.Background(), strings.NewReader(input), &b, opts, tojunit.ConvertOpt{}, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range []string{
+		`<testsuites tests="2" failures="1"`,
+		`name="named_test" tests="1" failures="0"`,
+		`name="named_test (2)" tests="1" failures="1"`,
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("missing %q in output:\n%v", s, b.String())
+		}
+	}
+}
+
+func TestCliDialect(t *testing.T) {
+	input := `1..1
+ok 1 fast in 250ms
+`
+	var b strings.Builder
+	opts := tap.ReadOpt{Name: "named_test", Dialect: tap.Bats}
+	if err := run(context.Background(), strings.NewReader(input), &b, opts, tojunit.ConvertOpt{}, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(b.String(), `name="fast" time="0.250"`) {
+		t.Errorf("the bats timing was not read:\n%v", b.String())
+	}
+}
+
+func TestCliDialectNotDetected(t *testing.T) {
+	input := "1..2\nok 1 - responds in 100ms\n# TAP2JUNIT: Duration: 2s\nok 2 - other\n# TAP2JUNIT: Duration: 3s\n"
+	for _, d := range []tap.Dialect{dialect, tap.Auto} {
+		var b strings.Builder
+		opts := tap.ReadOpt{Name: "named_test", Dialect: d}
+		if err := run(context.Background(), strings.NewReader(input), &b, opts, tojunit.ConvertOpt{}, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, s := range []string{
+			`name="responds in 100ms" time="2.000"`,
+			`name="other" time="3.000"`,
+		} {
+			if !strings.Contains(b.String(), s) {
+				t.Errorf("dialect %v: missing %q in output:\n%v", d, s, b.String())
+			}
+		}
+		if strings.Contains(b.String(), "system-out") {
+			t.Errorf("dialect %v: unexpected suite output:\n%v", d, b.String())
+		}
+	}
+}
+
+func TestCliInferDurations(t *testing.T) {
+	var b strings.Builder
+	opts := tap.ReadOpt{Name: "named_test", InferDurations: true}
+	if err := run(context.Background(), strings.NewReader("1..1\nok 1 - fine\n"), &b, opts, tojunit.ConvertOpt{}, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(b.String(), ` timestamp="`) {
+		t.Errorf("the suite has no timestamp:\n%v", b.String())
+	}
+}
+
+func TestCliPositions(t *testing.T) {
+	var b strings.Builder
+	opts := tap.ReadOpt{Name: "named_test"}
+	if err := run(context.Background(), strings.NewReader("1..1\nok 1 - fine\n"), &b, opts, tojunit.ConvertOpt{Positions: true}, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, p := range []string{
+		`<property name="tap.plan.line" value="1"></property>`,
+		`<property name="tap.line" value="2"></property>`,
+		`<property name="tap.offset" value="5"></property>`,
+	} {
+		if !strings.Contains(b.String(), p) {
+			t.Errorf("missing %v in:\n%v", p, b.String())
+		}
+	}
+}
+
+func TestCliTimeout(t *testing.T) {
+	// A producer that is stuck after its first test.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("1..2\nok 1 - first\n"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var b strings.Builder
+	opts := tap.ReadOpt{Name: "named_test"}
+	err := run(ctx, pr, &b, opts, tojunit.ConvertOpt{}, false)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("expected a deadline error, got: %v", err)
+	}
+	for _, s := range []string{`name="first"`, `type="Incomplete"`} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("missing %v in the partial report:\n%v", s, b.String())
+		}
+	}
+}
+
+func TestCliNumberGaps(t *testing.T) {
+	for _, test := range []struct {
+		input    string
+		expected []string
+	}{
+		{
+			input:    "1..2\nok 1 a\nok 2 b\nok 5 c\n",
+			expected: []string{`name="test 3 (not reported)"`, `name="test 4 (not reported)"`, `name="test 5 (out of plan)"`},
+		},
+		{
+			input:    "ok 1 a\nok 3 c\n",
+			expected: []string{`errors="1"`, `name="test 2 (not reported)"`},
+		},
+	} {
+		var b strings.Builder
+		opts := tap.ReadOpt{Name: "named_test"}
+		if err := run(context.Background(), strings.NewReader(test.input), &b, opts, tojunit.ConvertOpt{}, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(b.String(), `name=""`) {
+			t.Errorf("a test without a result is reported without a name:\n%v", b.String())
+		}
+		for _, e := range test.expected {
+			if !strings.Contains(b.String(), e) {
+				t.Errorf("missing %v in:\n%v", e, b.String())
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@
 package junit
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io"
@@ -21,6 +22,22 @@ func (d DurationSec) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
 	return xml.Attr{Name: name, Value: s}, nil
 }
 
+// Timestamp is a point in time, expressed as an ISO 8601 date and time
+// without a time zone when marshaling.  The zero Timestamp is omitted.
+type Timestamp struct {
+	time.Time
+}
+
+var _ xml.MarshalerAttr = Timestamp{}
+
+// MarshalXMLAttr implements xml.MarshalerAttr.
+func (t Timestamp) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	if t.IsZero() {
+		return xml.Attr{}, nil
+	}
+	return xml.Attr{Name: name, Value: t.Format("2006-01-02T15:04:05")}, nil
+}
+
 // Testsuites is a definition of the test suites.
 type Testsuites struct {
 	XMLName     xml.Name    `xml:"testsuites"`
@@ -28,6 +45,8 @@ type Testsuites struct {
 	Name        string      `xml:"name,attr,omitempty"`
 	NumTests    int         `xml:"tests,attr"`
 	NumFailures int         `xml:"failures,attr"`
+	NumErrors   int         `xml:"errors,attr,omitempty"`
+	NumSkipped  int         `xml:"skipped,attr,omitempty"`
 	Time        DurationSec `xml:"time,attr"`
 	Suites      []Suite
 	Data        string `xml:",cdata"`
@@ -39,17 +58,53 @@ type Suite struct {
 	Name        string      `xml:"name,attr"`
 	NumTests    int         `xml:"tests,attr"`
 	NumFailures int         `xml:"failures,attr"`
+	NumErrors   int         `xml:"errors,attr,omitempty"`
+	NumSkipped  int         `xml:"skipped,attr,omitempty"`
 	Time        DurationSec `xml:"time,attr"`
-	Testcases   []Case
+	// Timestamp is when the suite started running, if known.
+	Timestamp Timestamp `xml:"timestamp,attr"`
+	// Properties are named values attached to the suite.
+	Properties *Properties `xml:"properties,omitempty"`
+	Testcases  []Case
+	SystemOut  *Output `xml:"system-out,omitempty"`
+	SystemErr  *Output `xml:"system-err,omitempty"`
+}
+
+// Output is the output captured while testing.
+type Output struct {
+	Text string `xml:",cdata"`
 }
 
 // Case is a description of a single result test case.
 type Case struct {
-	XMLName  xml.Name    `xml:"testcase"`
-	ID       string      `xml:"id,attr"`
-	Name     string      `xml:"name,attr"`
-	Time     DurationSec `xml:"time,attr"`
-	Failures []Failure
+	XMLName xml.Name `xml:"testcase"`
+	ID      string   `xml:"id,attr"`
+	Name    string   `xml:"name,attr"`
+	// Classname is the dot-separated path of the enclosing test groups.
+	Classname string      `xml:"classname,attr,omitempty"`
+	Time      DurationSec `xml:"time,attr"`
+	// File and Line are where the test is defined.
+	File      string    `xml:"file,attr,omitempty"`
+	Line      int       `xml:"line,attr,omitempty"`
+	Timestamp Timestamp `xml:"timestamp,attr"`
+	// Properties are named values attached to the test.
+	Properties *Properties `xml:"properties,omitempty"`
+	Skipped    *Skipped
+	Failures   []Failure
+	Errors     []Error
+	SystemOut  *Output `xml:"system-out,omitempty"`
+	SystemErr  *Output `xml:"system-err,omitempty"`
+}
+
+// Properties are named values.
+type Properties struct {
+	Properties []Property `xml:"property"`
+}
+
+// Property is a named value.
+type Property struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
 }
 
 // Failure is a message about a single test failure.
@@ -60,6 +115,20 @@ type Failure struct {
 	Text    string   `xml:",cdata"`
 }
 
+// Skipped marks a test that did not run.
+type Skipped struct {
+	XMLName xml.Name `xml:"skipped"`
+	Message string   `xml:"message,attr,omitempty"`
+}
+
+// Error is a message about a test that could not run to completion.
+type Error struct {
+	XMLName xml.Name `xml:"error"`
+	Message string   `xml:"message,attr"`
+	Type    string   `xml:"type,attr"`
+	Text    string   `xml:",cdata"`
+}
+
 // Write writes out the test suites information into the supplied writer.
 func Write(suites Testsuites, w io.Writer, singleSuite bool) error {
 	e := xml.NewEncoder(w)
@@ -75,3 +144,40 @@ func Write(suites Testsuites, w io.Writer, singleSuite bool) error {
 	}
 	return e.Encode(suites)
 }
+
+// WriteContext is Write, which stops when ctx is done, even while w is
+// blocked.  It then returns the error of ctx, and w has only part of the
+// report.  A blocked write goes on in the background until w returns.
+func WriteContext(ctx context.Context, suites Testsuites, w io.Writer, singleSuite bool) error {
+	return Write(suites, &contextWriter{ctx: ctx, w: w}, singleSuite)
+}
+
+// contextWriter writes to w until ctx is done.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+type writeResult struct {
+	n   int
+	err error
+}
+
+func (c *contextWriter) Write(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	// The caller may reuse b once this returns, while w still writes it.
+	buf := append([]byte(nil), b...)
+	done := make(chan writeResult, 1)
+	go func() {
+		n, err := c.w.Write(buf)
+		done <- writeResult{n, err}
+	}()
+	select {
+	case <-c.ctx.Done():
+		return 0, c.ctx.Err()
+	case res := <-done:
+		return res.n, res.err
+	}
+}
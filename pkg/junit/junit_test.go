@@ -1,6 +1,9 @@
 package junit
 
 import (
+	"context"
+	"errors"
+	"io"
 	"strings"
 	"testing"
 	"time"
@@ -112,6 +115,62 @@ Line: 2
       </testcase>
    </testsuite>`,
 		},
+		{
+			name: "Skipped and errors",
+			input: Testsuites{
+				NumTests:   2,
+				NumErrors:  1,
+				NumSkipped: 1,
+				Suites: []Suite{
+					{
+						ID:         "suite",
+						Name:       "suite",
+						NumTests:   2,
+						NumErrors:  1,
+						NumSkipped: 1,
+						Timestamp:  Timestamp{time.Date(2014, 6, 12, 17, 5, 19, 0, time.UTC)},
+						Testcases: []Case{
+							{
+								ID:        "skipped",
+								Name:      "skipped",
+								Classname: "group",
+								Skipped:   &Skipped{Message: "no database"},
+							},
+							{
+								ID:        "error",
+								Name:      "error",
+								File:      "suite.sh",
+								Line:      12,
+								Timestamp: Timestamp{time.Date(2014, 6, 12, 17, 5, 20, 0, time.UTC)},
+								Properties: &Properties{
+									Properties: []Property{{Name: "owner", Value: "alice"}},
+								},
+								Errors: []Error{
+									{
+										Message: "did not run",
+										Type:    "NotRun",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expected: `<?xml version="1.0" encoding="UTF-8"?>
+   <testsuites tests="2" failures="0" errors="1" skipped="1" time="0.000">
+      <testsuite id="suite" name="suite" tests="2" failures="0" errors="1" skipped="1" time="0.000" timestamp="2014-06-12T17:05:19">
+         <testcase id="skipped" name="skipped" classname="group" time="0.000">
+            <skipped message="no database"></skipped>
+         </testcase>
+         <testcase id="error" name="error" time="0.000" file="suite.sh" line="12" timestamp="2014-06-12T17:05:20">
+            <properties>
+               <property name="owner" value="alice"></property>
+            </properties>
+            <error message="did not run" type="NotRun"></error>
+         </testcase>
+      </testsuite>
+   </testsuites>`,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -128,3 +187,23 @@ Line: 2
 		})
 	}
 }
+
+func TestWriteContext(t *testing.T) {
+	// A writer that blocks, as nothing reads from the pipe.
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := WriteContext(ctx, Testsuites{}, pw, false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	var out strings.Builder
+	if err := WriteContext(context.Background(), Testsuites{}, &out, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "<testsuites") {
+		t.Errorf("got:\n%v", out.String())
+	}
+}
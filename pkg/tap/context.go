@@ -0,0 +1,73 @@
+package tap
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// contextReader reads from r until ctx is done.  A read that is blocked when
+// ctx is done is abandoned: it goes on in the background until r returns, and
+// what it reads is dropped.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+	// buf is what the reads of r go to, so that an abandoned read does not
+	// write to the buffer of a later caller.
+	buf []byte
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func (c *contextReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if len(c.buf) < len(b) {
+		c.buf = make([]byte, len(b))
+	}
+	buf := c.buf[:len(b)]
+	done := make(chan readResult, 1)
+	go func() {
+		n, err := c.r.Read(buf)
+		done <- readResult{n, err}
+	}()
+	select {
+	case <-c.ctx.Done():
+		c.buf = nil
+		return 0, c.ctx.Err()
+	case res := <-done:
+		copy(b, buf[:res.n])
+		return res.n, res.err
+	}
+}
+
+// stopped returns true if err is the error of ctx being done.
+func stopped(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err())
+}
+
+// ReadContext is Read, which stops when ctx is done, even while waiting for
+// input.  It then returns the error of ctx, and what was read so far, with
+// Case.Incomplete set.
+func ReadContext(ctx context.Context, i io.Reader, opt ReadOpt) (Case, error) {
+	c, err := Read(&contextReader{ctx: ctx, r: i}, opt)
+	if stopped(ctx, err) {
+		c.Incomplete = true
+	}
+	return c, err
+}
+
+// ReadAllContext is ReadAll, which stops when ctx is done, even while waiting
+// for input.  It then returns the error of ctx, and the documents read so
+// far, the last one with Case.Incomplete set.
+func ReadAllContext(ctx context.Context, i io.Reader, opt ReadOpt) ([]Case, error) {
+	cs, err := ReadAll(&contextReader{ctx: ctx, r: i}, opt)
+	if stopped(ctx, err) && len(cs) > 0 {
+		cs[len(cs)-1].Incomplete = true
+	}
+	return cs, err
+}
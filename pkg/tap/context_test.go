@@ -0,0 +1,57 @@
+package tap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stuck returns a reader that gives input, and then blocks until it is
+// closed.
+func stuck(t *testing.T, input string) io.Reader {
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { pw.Close() })
+	go pw.Write([]byte(input))
+	return pr
+}
+
+func TestReadContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	c, err := ReadContext(ctx, stuck(t, "1..3\nok 1 - first\nnot ok 2 - second\n"), ReadOpt{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+	if !c.Incomplete {
+		t.Errorf("the partial case is not flagged as incomplete")
+	}
+	var names []string
+	for _, r := range c.Results {
+		names = append(names, r.Description)
+	}
+	if len(names) != 2 || names[0] != "first" || names[1] != "second" {
+		t.Errorf("got results %q, expected first and second", names)
+	}
+
+	// A complete input is not flagged.
+	c, err = ReadContext(context.Background(), strings.NewReader("1..1\nok 1\n"), ReadOpt{})
+	if err != nil || c.Incomplete {
+		t.Errorf("got incomplete: %v, error: %v, expected a complete case", c.Incomplete, err)
+	}
+}
+
+func TestReadAllContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	input := "TAP version 13\n1..1\nok 1\nTAP version 13\n1..2\nok 1\n"
+	cs, err := ReadAllContext(ctx, stuck(t, input), ReadOpt{Name: "doc"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+	if len(cs) != 2 || cs[0].Incomplete || !cs[1].Incomplete {
+		t.Errorf("expected the second of 2 documents to be incomplete, got: %+v", cs)
+	}
+}
@@ -0,0 +1,145 @@
+package tap
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Dialect is the program that produced a TAP stream.  Each producer has its
+// own quirks, which Read works around once it knows the dialect.  It is
+// written as its name in text and in JSON, such as "bats".
+type Dialect int
+
+const (
+	// Generic is TAP as specified, with no quirks.
+	Generic Dialect = Dialect(0)
+	// Bats is the output of bats-core.  Its duration annotations come before
+	// the test they belong to, see
+	// https://github.com/bats-core/bats-core/issues/187, and with --timing
+	// its test descriptions end with the duration: "ok 1 name in 12ms".
+	Bats = Dialect(1)
+	// NodeTap is the output of node-tap and of the node test runner.  Its
+	// "duration_ms" diagnostics and "# time=" comments give the test
+	// durations, which is understood in any dialect.
+	NodeTap = Dialect(2)
+	// Perl is the output of Test::More and friends, as run by prove.  The
+	// closing "# Looks like you failed..." comments belong to the suite, not
+	// to its last test.
+	Perl = Dialect(3)
+	// Pytest is the output of pytest-tap.  Its "# TAP results for ..."
+	// comments head a group of tests, they do not belong to the test before.
+	Pytest = Dialect(4)
+	// Auto detects the dialect from the stream.  Until a line gives the
+	// producer away, the stream is read as Generic.  Bats is never detected:
+	// its timing suffix may well be part of a test description, and its
+	// durations would have to be reordered from the first test on.
+	Auto = Dialect(-1)
+)
+
+var dialectNames = map[Dialect]string{
+	Generic: "generic",
+	Bats:    "bats",
+	NodeTap: "node-tap",
+	Perl:    "perl",
+	Pytest:  "pytest",
+	Auto:    "auto",
+}
+
+// String implements flag.Value and fmt.Stringer.
+func (d Dialect) String() string {
+	if n, ok := dialectNames[d]; ok {
+		return n
+	}
+	return fmt.Sprintf("Dialect(%d)", int(d))
+}
+
+// Set implements flag.Value.
+func (d *Dialect) Set(s string) error {
+	return d.UnmarshalText([]byte(s))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Dialect) MarshalText() ([]byte, error) {
+	n, ok := dialectNames[d]
+	if !ok {
+		return nil, fmt.Errorf("unknown dialect: %d", int(d))
+	}
+	return []byte(n), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Dialect) UnmarshalText(b []byte) error {
+	for k, v := range dialectNames {
+		if v == string(b) {
+			*d = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown dialect: %q, want one of: generic, bats, node-tap, perl, pytest, auto", b)
+}
+
+// quirks are the workarounds for the peculiarities of a dialect.
+type quirks struct {
+	// reorderDuration attributes the duration annotations to the next test,
+	// as ReadOpt.ReorderDuration does.
+	reorderDuration bool
+	// timingSuffix takes the duration from a " in 12ms" suffix of the test
+	// description.
+	timingSuffix bool
+	// suiteComments are the prefixes of the comments that belong to the
+	// suite rather than to a test.
+	suiteComments []string
+}
+
+var dialects = map[Dialect]quirks{
+	Bats:    {reorderDuration: true, timingSuffix: true},
+	NodeTap: {},
+	Perl:    {suiteComments: []string{"# Looks like "}},
+	Pytest:  {suiteComments: []string{"# TAP results for "}},
+}
+
+var (
+	// batsTiming is the duration that bats --timing appends to the test
+	// description.
+	batsTiming = regexp.MustCompile(`^(.*) in (\d{1,12})ms$`)
+
+	// durationMS is the node-tap duration in the YAML diagnostics.
+	durationMS = regexp.MustCompile(`(?m)^duration_ms:`)
+)
+
+// detect returns the dialect that the event e gives away, if any.  None of
+// the dialects it detects reorder durations, which would otherwise be turned
+// on after some tests were read.
+func detect(e Event) (Dialect, bool) {
+	switch e := e.(type) {
+	case TestPoint:
+		if strings.Contains(e.Description, ".py::") {
+			return Pytest, true
+		}
+	case Diagnostic:
+		if durationMS.MatchString(e.YAML) {
+			return NodeTap, true
+		}
+	case Comment:
+		for d, q := range dialects {
+			for _, p := range q.suiteComments {
+				if strings.HasPrefix(e.Text, p) {
+					return d, true
+				}
+			}
+		}
+	}
+	return Generic, false
+}
+
+// cutTiming removes the bats --timing suffix from the description s, and
+// returns the duration that it gives.
+func cutTiming(s string) (string, time.Duration, bool) {
+	v := batsTiming.FindStringSubmatch(s)
+	if v == nil {
+		return s, 0, false
+	}
+	return v[1], time.Duration(toInt(v[2])) * time.Millisecond, true
+}
@@ -0,0 +1,191 @@
+package tap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDialects(t *testing.T) {
+	// result summarizes a Result.
+	type result struct {
+		Description string
+		Duration    time.Duration
+	}
+	tests := []struct {
+		name     string
+		input    string
+		dialect  Dialect
+		expected Dialect
+		tests    []result
+		output   string
+	}{
+		{
+			name: "Bats",
+			input: `1..2
+ok 1 first in 12ms
+# TAP2JUNIT: Duration: 2s
+ok 2 second in 1500ms
+`,
+			dialect:  Bats,
+			expected: Bats,
+			tests: []result{
+				{Description: "first", Duration: 12 * time.Millisecond},
+				{Description: "second", Duration: 2 * time.Second},
+			},
+		},
+		{
+			name: "Bats not detected from the description",
+			input: `1..2
+ok 1 - responds in 100ms
+# TAP2JUNIT: Duration: 2s
+ok 2 - other
+# TAP2JUNIT: Duration: 3s
+`,
+			dialect:  Auto,
+			expected: Generic,
+			tests: []result{
+				{Description: "responds in 100ms", Duration: 2 * time.Second},
+				{Description: "other", Duration: 3 * time.Second},
+			},
+		},
+		{
+			name: "Bats not detected",
+			input: `1..1
+ok 1 first in 12ms
+`,
+			dialect:  Generic,
+			expected: Generic,
+			tests: []result{
+				{Description: "first in 12ms"},
+			},
+		},
+		{
+			name: "Node",
+			input: `TAP version 14
+ok 1 - first
+  ---
+  duration_ms: 1.5
+  ...
+ok 2 - second
+  ---
+  duration_ms: 20
+  ...
+1..2
+`,
+			dialect:  Auto,
+			expected: NodeTap,
+			tests: []result{
+				{Description: "first", Duration: 1500 * time.Microsecond},
+				{Description: "second", Duration: 20 * time.Millisecond},
+			},
+		},
+		{
+			name: "Perl",
+			input: `1..2
+ok 1 - first
+not ok 2 - second
+# Looks like you failed 1 test of 2.
+`,
+			dialect:  Auto,
+			expected: Perl,
+			tests: []result{
+				{Description: "first"},
+				{Description: "second"},
+			},
+			output: "# Looks like you failed 1 test of 2.",
+		},
+		{
+			name: "Pytest",
+			input: `TAP version 13
+# TAP results for TestThings
+ok 1 test_things.py::TestThings::test_one
+# TAP results for TestOthers
+ok 2 test_things.py::TestOthers::test_two
+1..2
+`,
+			dialect:  Auto,
+			expected: Pytest,
+			tests: []result{
+				{Description: "test_things.py::TestThings::test_one"},
+				{Description: "test_things.py::TestOthers::test_two"},
+			},
+			output: "# TAP results for TestThings\n# TAP results for TestOthers",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			c, err := Read(strings.NewReader(test.input), ReadOpt{Dialect: test.dialect, Strict: true})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.Dialect != test.expected {
+				t.Errorf("got dialect %v, expected %v", c.Dialect, test.expected)
+			}
+			var actual []result
+			for _, r := range c.Results {
+				actual = append(actual, result{Description: r.Description, Duration: r.Duration})
+			}
+			if !cmp.Equal(test.tests, actual) {
+				t.Errorf("diff:\n%v", cmp.Diff(test.tests, actual))
+			}
+			if c.Output != test.output {
+				t.Errorf("got output %q, expected %q", c.Output, test.output)
+			}
+		})
+	}
+}
+
+func TestDialectFlag(t *testing.T) {
+	var d Dialect
+	for _, s := range []string{"generic", "bats", "node-tap", "perl", "pytest", "auto"} {
+		if err := d.Set(s); err != nil {
+			t.Errorf("Set(%q): unexpected error: %v", s, err)
+		}
+		if d.String() != s {
+			t.Errorf("Set(%q): got %q", s, d.String())
+		}
+	}
+	if err := d.Set("cobol"); err == nil {
+		t.Errorf("Set(%q): expected an error", "cobol")
+	}
+}
+
+func TestDialectText(t *testing.T) {
+	if s := fmt.Sprint(Bats); s != "bats" {
+		t.Errorf("got %q, expected bats", s)
+	}
+	if s := Dialect(42).String(); s != "Dialect(42)" {
+		t.Errorf("got %q, expected Dialect(42)", s)
+	}
+	if _, err := Dialect(42).MarshalText(); err == nil {
+		t.Errorf("expected an error for an unknown dialect")
+	}
+
+	b, err := json.Marshal([]Dialect{Generic, Perl, Auto})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `["generic","perl","auto"]`; string(b) != expected {
+		t.Errorf("got %s, expected %s", b, expected)
+	}
+	var actual []Dialect
+	if err := json.Unmarshal([]byte(`["bats", "pytest", "node-tap"]`), &actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []Dialect{Bats, Pytest, NodeTap}; !cmp.Equal(expected, actual) {
+		t.Errorf("diff:\n%v", cmp.Diff(expected, actual))
+	}
+	for _, input := range []string{`"cobol"`, `4`, `true`} {
+		var d Dialect
+		if err := json.Unmarshal([]byte(input), &d); err == nil {
+			t.Errorf("expected an error for %s, got %v", input, d)
+		}
+	}
+}
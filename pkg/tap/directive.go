@@ -0,0 +1,111 @@
+package tap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directive reads the value of a "# TAP2JUNIT: Name: value" annotation, and
+// returns how it changes the result of the test that it annotates.  An error
+// is reported as a problem with the input line.
+type Directive func(value string) (func(*Result), error)
+
+// Property is a named value attached to a test.
+// "# TAP2JUNIT: Property: owner=alice"
+type Property struct {
+	Name, Value string
+}
+
+var (
+	directivesMu sync.RWMutex
+	// directives are the handlers of the TAP2JUNIT annotations, by name.
+	directives = map[string]Directive{
+		"Duration":   durationDirective,
+		"Classname":  stringDirective(func(r *Result, v string) { r.Classname = v }),
+		"File":       stringDirective(func(r *Result, v string) { r.File = v }),
+		"Line":       lineDirective,
+		"Property":   propertyDirective,
+		"Start":      timeDirective(func(r *Result, t time.Time) { r.Start = t }),
+		"End":        timeDirective(func(r *Result, t time.Time) { r.End = t }),
+		"Attachment": stringDirective(func(r *Result, v string) { r.Attachments = append(r.Attachments, v) }),
+	}
+)
+
+// RegisterDirective makes the annotation "# TAP2JUNIT: name: value" handled
+// by d.  It panics if name is already registered, built-ins included.
+func RegisterDirective(name string, d Directive) {
+	directivesMu.Lock()
+	defer directivesMu.Unlock()
+	if _, ok := directives[name]; ok {
+		panic(fmt.Sprintf("tap: directive %q registered twice", name))
+	}
+	directives[name] = d
+}
+
+// directive returns the handler of the annotation name, if any.
+func directive(name string) (Directive, bool) {
+	directivesMu.RLock()
+	defer directivesMu.RUnlock()
+	d, ok := directives[name]
+	return d, ok
+}
+
+// "# TAP2JUNIT: Duration: 1.5s", or any format that parseDuration accepts.
+func durationDirective(v string) (func(*Result), error) {
+	d, err := parseDuration(v)
+	if err != nil {
+		return nil, err
+	}
+	return func(r *Result) { r.Duration = d }, nil
+}
+
+// "# TAP2JUNIT: Line: 42"
+func lineDirective(v string) (func(*Result), error) {
+	l, err := strconv.Atoi(v)
+	if err != nil || l < 1 {
+		return nil, fmt.Errorf("invalid line: %q", v)
+	}
+	return func(r *Result) { r.Line = l }, nil
+}
+
+// "# TAP2JUNIT: Property: name=value"
+func propertyDirective(v string) (func(*Result), error) {
+	name, value, ok := strings.Cut(v, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid property, want name=value: %q", v)
+	}
+	p := Property{Name: name, Value: strings.TrimSpace(value)}
+	return func(r *Result) { r.Properties = append(r.Properties, p) }, nil
+}
+
+// stringDirective is an annotation that sets its value, as is, with set.
+func stringDirective(set func(r *Result, v string)) Directive {
+	return func(v string) (func(*Result), error) {
+		if v == "" {
+			return nil, fmt.Errorf("empty value")
+		}
+		return func(r *Result) { set(r, v) }, nil
+	}
+}
+
+// timeDirective is an RFC 3339 timestamp annotation, set with set.  Once both
+// the start and the end of a test are known, they give its duration.
+// "# TAP2JUNIT: Start: 2024-06-01T12:00:00.25Z"
+func timeDirective(set func(r *Result, t time.Time)) Directive {
+	return func(v string) (func(*Result), error) {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp, want RFC 3339: %q", v)
+		}
+		return func(r *Result) {
+			set(r, t)
+			if !r.Start.IsZero() && !r.End.IsZero() && !r.End.Before(r.Start) {
+				r.Duration = r.End.Sub(r.Start)
+			}
+		}, nil
+	}
+}
@@ -0,0 +1,85 @@
+package tap
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestDirectives(t *testing.T) {
+	input := `1..2
+ok 1 - first
+# TAP2JUNIT: Classname: suite.Things
+# TAP2JUNIT: File: tests/things.sh
+# TAP2JUNIT: Line: 12
+# TAP2JUNIT: Property: owner = alice
+# TAP2JUNIT: Property: ticket=BUG-1
+# TAP2JUNIT: Start: 2024-06-01T12:00:00Z
+# TAP2JUNIT: End: 2024-06-01T12:00:01.5Z
+# TAP2JUNIT: Attachment: out/first.log
+not ok 2 - second
+# TAP2JUNIT: Start: 2024-06-01T12:00:00Z
+# TAP2JUNIT: End: 2024-06-01T12:00:03Z
+# TAP2JUNIT: Duration: 2s
+`
+	c, err := Read(strings.NewReader(input), ReadOpt{Strict: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	expected := []Result{
+		{
+			Duration:  1500 * time.Millisecond,
+			Classname: "suite.Things",
+			File:      "tests/things.sh",
+			Line:      12,
+			Properties: []Property{
+				{Name: "owner", Value: "alice"},
+				{Name: "ticket", Value: "BUG-1"},
+			},
+			Start:       start,
+			End:         start.Add(1500 * time.Millisecond),
+			Attachments: []string{"out/first.log"},
+		},
+		{
+			// The duration annotation follows the timestamps, and wins.
+			Duration: 2 * time.Second,
+			Start:    start,
+			End:      start.Add(3 * time.Second),
+		},
+	}
+	opts := cmpopts.IgnoreFields(Result{},
+		"Status", "OK", "Header", "Number", "Description", "Raw", "Span")
+	if !cmp.Equal(expected, c.Results, opts) {
+		t.Errorf("diff:\n%v", cmp.Diff(expected, c.Results, opts))
+	}
+}
+
+func TestRegisterDirective(t *testing.T) {
+	// Directives stay registered, and the test may run more than once.
+	if _, ok := directive("Owner"); !ok {
+		RegisterDirective("Owner", func(v string) (func(*Result), error) {
+			return func(r *Result) {
+				r.Properties = append(r.Properties, Property{Name: "owner", Value: v})
+			}, nil
+		})
+	}
+	c, err := Read(strings.NewReader("ok 1\n# TAP2JUNIT: Owner: bob\n"), ReadOpt{Strict: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Property{{Name: "owner", Value: "bob"}}
+	if !cmp.Equal(expected, c.Results[0].Properties) {
+		t.Errorf("diff:\n%v", cmp.Diff(expected, c.Results[0].Properties))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a built-in directive again did not panic")
+		}
+	}()
+	RegisterDirective("Duration", durationDirective)
+}
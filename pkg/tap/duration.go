@@ -0,0 +1,78 @@
+package tap
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// seconds is a duration in plain seconds.
+	// "1.25"
+	seconds = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+	// iso8601 is an ISO 8601 duration, without years and months, which have
+	// no fixed length.
+	// "PT1.5S", "P1DT2H30M"
+	iso8601 = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+	// timeComment is the duration that node-tap writes as the comment of a
+	// test point, or as a comment line at the end of a subtest.
+	// "# time=12.5ms"
+	timeComment = regexp.MustCompile(`^time=(\S+)$`)
+)
+
+// maxSeconds is the longest duration, in seconds.
+const maxSeconds = float64(math.MaxInt64 / time.Second)
+
+// parseDuration parses a test duration, in any of the formats used by TAP
+// producers: a Go duration, such as "1.5s" or "1250ms", plain seconds, such as
+// "1.25", or an ISO 8601 duration, such as "PT1.5S".
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if seconds.MatchString(s) {
+		return fromSeconds(s, 1)
+	}
+	if v := iso8601.FindStringSubmatch(strings.ReplaceAll(s, ",", ".")); v != nil && s != "P" && !strings.HasSuffix(s, "T") {
+		var d time.Duration
+		for i, unit := range []float64{24 * 60 * 60, 60 * 60, 60, 1} {
+			if v[i+1] == "" {
+				continue
+			}
+			p, err := fromSeconds(v[i+1], unit)
+			if err != nil || p > math.MaxInt64-d {
+				return 0, fmt.Errorf("invalid duration: %q", s)
+			}
+			d += p
+		}
+		return d, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration: %q", s)
+	}
+	return d, nil
+}
+
+// parseMilliseconds parses a duration in plain milliseconds, as in the
+// "duration_ms" YAML diagnostics.
+func parseMilliseconds(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if !seconds.MatchString(s) {
+		return 0, fmt.Errorf("invalid duration in milliseconds: %q", s)
+	}
+	return fromSeconds(s, 0.001)
+}
+
+// fromSeconds returns the number s of units, which are unit seconds long.
+// s is known to be a non-negative decimal number.
+func fromSeconds(s string, unit float64) (time.Duration, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f*unit > maxSeconds {
+		return 0, fmt.Errorf("invalid duration: %q", s)
+	}
+	return time.Duration(math.Round(f * unit * float64(time.Second))), nil
+}
@@ -0,0 +1,93 @@
+package tap
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		err      bool
+	}{
+		{input: "1.5s", expected: 1500 * time.Millisecond},
+		{input: "1250ms", expected: 1250 * time.Millisecond},
+		{input: "1h2m", expected: time.Hour + 2*time.Minute},
+		{input: "1.25", expected: 1250 * time.Millisecond},
+		{input: "3", expected: 3 * time.Second},
+		{input: " 0.001 ", expected: time.Millisecond},
+		{input: "PT1.5S", expected: 1500 * time.Millisecond},
+		{input: "PT0,25S", expected: 250 * time.Millisecond},
+		{input: "P1DT2H30M", expected: 26*time.Hour + 30*time.Minute},
+		{input: "PT1M", expected: time.Minute},
+		{input: "P2D", expected: 48 * time.Hour},
+		{input: "P", err: true},
+		{input: "PT", err: true},
+		{input: "P1DT", err: true},
+		{input: "P1Y", err: true},
+		{input: "-1s", err: true},
+		{input: "-1", err: true},
+		{input: "1e3", err: true},
+		{input: "forever", err: true},
+		{input: "", err: true},
+		{input: "99999999999999999999", err: true},
+		{input: "P99999999999999D", err: true},
+	}
+	for _, test := range tests {
+		actual, err := parseDuration(test.input)
+		if (err != nil) != test.err {
+			t.Errorf("parseDuration(%q): got error %v, expected error: %v", test.input, err, test.err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("parseDuration(%q) = %v, expected %v", test.input, actual, test.expected)
+		}
+	}
+}
+
+func TestDurations(t *testing.T) {
+	input := `1..6
+ok 1 - seconds
+# TAP2JUNIT: Duration: 1.25
+ok 2 - ISO 8601
+# TAP2JUNIT: Duration: PT2S
+ok 3 - milliseconds
+  ---
+  duration_ms: 3000
+  ...
+ok 4 - suffix # time=4000ms
+# Subtest: sub
+    ok 1 - inner # time=1ms
+    1..1
+    # time=5s
+ok 5 - sub
+ok 6 - skipped # SKIP time=1s is not a duration
+# time=20s
+`
+	c, err := Read(strings.NewReader(input), ReadOpt{Strict: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var actual []time.Duration
+	for _, r := range c.Results {
+		actual = append(actual, r.Duration)
+	}
+	expected := []time.Duration{
+		1250 * time.Millisecond,
+		2 * time.Second,
+		3 * time.Second,
+		4 * time.Second,
+		5 * time.Second,
+		0,
+	}
+	if !cmp.Equal(expected, actual) {
+		t.Errorf("diff:\n%v", cmp.Diff(expected, actual))
+	}
+	if c.Duration != 20*time.Second {
+		t.Errorf("got suite duration %v, expected 20s", c.Duration)
+	}
+}
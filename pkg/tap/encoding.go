@@ -0,0 +1,131 @@
+package tap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding is how the input of a Case was encoded, and what was changed to
+// read it.  The zero Encoding is UTF-8 that was read as is.
+type Encoding struct {
+	// Charset is the character encoding of the input, if it is not UTF-8:
+	// "UTF-16LE" or "UTF-16BE".  UTF-16 is read as UTF-8, which the byte
+	// offsets of the input then refer to.
+	Charset string
+	// BOM is set if the input started with a byte order mark, which was
+	// removed.
+	BOM bool
+	// CRLF is set if lines ended with "\r\n", instead of "\n".
+	CRLF bool
+	// InvalidUTF8 is set if lines were not valid UTF-8.  The invalid bytes
+	// were replaced with U+FFFD.
+	InvalidUTF8 bool
+}
+
+const (
+	utf16LE = "UTF-16LE"
+	utf16BE = "UTF-16BE"
+)
+
+// utf8BOM is the UTF-8 byte order mark.
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// decoder reads UTF-16 from r as UTF-8.  It finds out the encoding from the
+// byte order mark at the start of r, or from the NUL bytes of the ASCII text
+// that TAP starts with.  Other input is read as is.
+type decoder struct {
+	r *bufio.Reader
+	// order is the byte order of UTF-16, or nil if r is not UTF-16.
+	order binary.ByteOrder
+	// charset and bom are what was found out about r.
+	charset  string
+	bom      bool
+	detected bool
+	// in is the UTF-16 read from r, and not decoded yet.  out is the UTF-8
+	// decoded, and not returned yet.
+	in, out []byte
+	err     error
+}
+
+func newDecoder(r io.Reader) *decoder {
+	return &decoder{r: bufio.NewReader(r)}
+}
+
+// detect finds out the encoding of the input.
+func (d *decoder) detect() {
+	d.detected = true
+	b, _ := d.r.Peek(3)
+	switch {
+	case bytes.HasPrefix(b, utf8BOM):
+		// The UTF-8 byte order mark is left to the Parser, so that the
+		// byte offsets are those of the input.
+		d.bom = true
+	case len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe:
+		d.order, d.charset, d.bom = binary.LittleEndian, utf16LE, true
+		d.r.Discard(2)
+	case len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff:
+		d.order, d.charset, d.bom = binary.BigEndian, utf16BE, true
+		d.r.Discard(2)
+	case len(b) >= 2 && b[0] != 0 && b[0] < utf8.RuneSelf && b[1] == 0:
+		d.order, d.charset = binary.LittleEndian, utf16LE
+	case len(b) >= 2 && b[0] == 0 && b[1] != 0 && b[1] < utf8.RuneSelf:
+		d.order, d.charset = binary.BigEndian, utf16BE
+	}
+}
+
+func (d *decoder) Read(b []byte) (int, error) {
+	if !d.detected {
+		d.detect()
+	}
+	if d.order == nil {
+		return d.r.Read(b)
+	}
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.decode()
+	}
+	n := copy(b, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// decode reads some UTF-16 from r, and decodes what it can of it.
+func (d *decoder) decode() {
+	var buf [4096]byte
+	n, err := d.r.Read(buf[:])
+	d.in = append(d.in, buf[:n]...)
+	d.err = err
+	d.out = d.out[:0]
+	i := 0
+	for ; i+2 <= len(d.in); i += 2 {
+		u := rune(d.order.Uint16(d.in[i:]))
+		if !utf16.IsSurrogate(u) {
+			d.out = utf8.AppendRune(d.out, u)
+			continue
+		}
+		if i+4 > len(d.in) && d.err == nil {
+			// The rest of the surrogate pair is not read yet.
+			break
+		}
+		r := utf8.RuneError
+		if i+4 <= len(d.in) {
+			r = utf16.DecodeRune(u, rune(d.order.Uint16(d.in[i+2:])))
+		}
+		if r != utf8.RuneError {
+			i += 2
+		}
+		d.out = utf8.AppendRune(d.out, r)
+	}
+	d.in = append(d.in[:0], d.in[i:]...)
+	if d.err != nil && len(d.in) > 0 {
+		// An odd byte at the end.
+		d.out = utf8.AppendRune(d.out, utf8.RuneError)
+		d.in = d.in[:0]
+	}
+}
@@ -0,0 +1,130 @@
+package tap
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf16"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// encodeUTF16 returns s as UTF-16 in the byte order o.
+func encodeUTF16(s string, o binary.ByteOrder) string {
+	us := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(us))
+	for i, u := range us {
+		o.PutUint16(b[2*i:], u)
+	}
+	return string(b)
+}
+
+func TestEncodings(t *testing.T) {
+	const input = "TAP version 13\n1..2\nok 1 - first 😀\nnot ok 2 - second\n  ---\n  at: here\n  ...\n"
+	tests := []struct {
+		name     string
+		input    string
+		expected Encoding
+		// descriptions are the expected test descriptions, if not those of
+		// input.
+		descriptions []string
+		warnings     int
+	}{
+		{
+			name:  "UTF-8",
+			input: input,
+		},
+		{
+			name:     "CRLF",
+			input:    strings.ReplaceAll(input, "\n", "\r\n"),
+			expected: Encoding{CRLF: true},
+		},
+		{
+			name:     "CRLF in YAML only",
+			input:    strings.Replace(input, "at: here\n", "at: here\r\n", 1),
+			expected: Encoding{CRLF: true},
+		},
+		{
+			name:     "UTF-8 BOM",
+			input:    "\ufeff" + input,
+			expected: Encoding{BOM: true},
+		},
+		{
+			name:     "UTF-8 BOM and a blank line",
+			input:    "\ufeff\n" + input,
+			expected: Encoding{BOM: true},
+		},
+		{
+			name:     "UTF-16LE BOM and a blank line",
+			input:    "\xff\xfe" + encodeUTF16("\n"+input, binary.LittleEndian),
+			expected: Encoding{Charset: "UTF-16LE", BOM: true},
+		},
+		{
+			name:     "UTF-16LE BOM",
+			input:    "\xff\xfe" + encodeUTF16(input, binary.LittleEndian),
+			expected: Encoding{Charset: "UTF-16LE", BOM: true},
+		},
+		{
+			name:     "UTF-16BE BOM and CRLF",
+			input:    "\xfe\xff" + encodeUTF16(strings.ReplaceAll(input, "\n", "\r\n"), binary.BigEndian),
+			expected: Encoding{Charset: "UTF-16BE", BOM: true, CRLF: true},
+		},
+		{
+			name:     "UTF-16LE",
+			input:    encodeUTF16(input, binary.LittleEndian),
+			expected: Encoding{Charset: "UTF-16LE"},
+		},
+		{
+			name:     "UTF-16BE",
+			input:    encodeUTF16(input, binary.BigEndian),
+			expected: Encoding{Charset: "UTF-16BE"},
+		},
+		{
+			name:         "Invalid UTF-8",
+			input:        strings.Replace(input, "second", "sec\xffond\xc3", 1),
+			expected:     Encoding{InvalidUTF8: true},
+			descriptions: []string{"first 😀", "sec�ond�"},
+		},
+		{
+			name: "Invalid UTF-16",
+			// A lone surrogate, and an odd byte at the end, which is a line
+			// that is not TAP.
+			input: strings.Replace(encodeUTF16(input, binary.LittleEndian),
+				encodeUTF16("second", binary.LittleEndian),
+				encodeUTF16("sec", binary.LittleEndian)+"\x00\xd8"+encodeUTF16("ond", binary.LittleEndian), 1) + "x",
+			expected:     Encoding{Charset: "UTF-16LE"},
+			descriptions: []string{"first 😀", "sec�ond"},
+			warnings:     1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// One byte at a time, to split the characters between reads.
+			c, err := Read(iotest.OneByteReader(strings.NewReader(test.input)), ReadOpt{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(c.Warnings) != test.warnings {
+				t.Errorf("got warnings %v, expected %d", c.Warnings, test.warnings)
+			}
+			if !cmp.Equal(test.expected, c.Encoding) {
+				t.Errorf("encoding diff:\n%v", cmp.Diff(test.expected, c.Encoding))
+			}
+			expected := test.descriptions
+			if expected == nil {
+				expected = []string{"first 😀", "second"}
+			}
+			var actual []string
+			for _, r := range c.Results {
+				actual = append(actual, r.Description)
+			}
+			if !cmp.Equal(expected, actual) {
+				t.Errorf("descriptions diff:\n%v", cmp.Diff(expected, actual))
+			}
+			if len(c.Results) == 2 && c.Results[1].Diagnostics["at"] != "here" {
+				t.Errorf("got diagnostics %v, expected at: here", c.Results[1].Diagnostics)
+			}
+		})
+	}
+}
@@ -0,0 +1,707 @@
+package tap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/golang/glog"
+)
+
+// Token is the part of the input that an event was read from.
+type Token struct {
+	// Line is the number of the first input line of the event, starting
+	// at 1.
+	Line int
+	// Depth is the subtest nesting depth of the event, 0 at the top level.
+	Depth int
+	// EndLine is the number of the last input line of the event.
+	EndLine int
+	// Offset and EndOffset are the byte offsets in the input of the start of
+	// the first line of the event, and of the end of its last line, line
+	// ending included.
+	Offset, EndOffset int64
+	// Text is the input text of the event, without the subtest indentation.
+	Text string
+	// Truncated is set if a line of the event was longer than
+	// Parser.MaxLineLength, and was cut short.
+	Truncated bool
+	// CRLF is set if a line of the event ended with "\r\n".
+	CRLF bool
+	// InvalidUTF8 is set if a line of the event was not valid UTF-8, and its
+	// invalid bytes were replaced with U+FFFD.
+	InvalidUTF8 bool
+}
+
+// Input returns the part of the input that the event was read from.
+func (t Token) Input() Token {
+	return t
+}
+
+// Span returns the lines of the input that the event was read from.
+func (t Token) Span() Span {
+	return Span{Line: t.Line, EndLine: t.EndLine, Offset: t.Offset, EndOffset: t.EndOffset}
+}
+
+// Span is a range of input lines.
+type Span struct {
+	// Line and EndLine are the numbers of the first and the last line,
+	// starting at 1.
+	Line, EndLine int
+	// Offset and EndOffset are the byte offsets of the start of the first
+	// line, and of the end of the last line, line ending included.
+	Offset, EndOffset int64
+}
+
+// add extends s to cover o as well.
+func (s *Span) add(o Span) {
+	if o.Line == 0 {
+		return
+	}
+	if s.Line == 0 || o.Line < s.Line {
+		s.Line, s.Offset = o.Line, o.Offset
+	}
+	if o.EndLine > s.EndLine {
+		s.EndLine, s.EndOffset = o.EndLine, o.EndOffset
+	}
+}
+
+// Event is an element of a TAP stream, as emitted by a Parser.  It is one of
+// Version, Pragma, Plan, TestPoint, Diagnostic, Comment, Subtest, BailOut or
+// Unknown.
+type Event interface {
+	// Input returns the part of the input that the event was read from.
+	Input() Token
+}
+
+// Version is the TAP version line.
+// "TAP version 13"
+type Version struct {
+	Token
+	// Version is the TAP specification version.
+	Version int
+}
+
+// Pragma turns a parsing behavior on or off, from the next line on.
+// "pragma +strict"
+type Pragma struct {
+	Token
+	// Key is the name of the behavior.
+	Key string
+	// On is set for "+", as opposed to "-".
+	On bool
+}
+
+// Plan is the range of the tests to run.
+// "1..42", or "1..0 # SKIP some reason" if all tests were skipped.
+type Plan struct {
+	Token
+	// First and Last are the first and the last planned test.
+	First, Last int
+	// Skip is set if the plan is empty, meaning that all tests were skipped.
+	Skip bool
+	// SkipReason is the reason given in a "1..0 # SKIP reason" plan.
+	SkipReason string
+}
+
+// TestPoint is a test result line.
+// "not ok 42 - some test # SKIP some comment"
+type TestPoint struct {
+	Token
+	// OK is set for "ok", as opposed to "not ok".
+	OK bool
+	// Number is the test number, nil if the test point has none.
+	Number *int
+	// Header is the text between the test number and the directive.
+	Header string
+	// Description is the test description, without the customary "- "
+	// separator.
+	Description string
+	// Directive is the test directive, "SKIP" or "TODO", if any.
+	Directive string
+	// DirectiveReason is the explanation that follows the directive.
+	DirectiveReason string
+}
+
+// Diagnostic is a YAML diagnostic block following a test point.
+type Diagnostic struct {
+	Token
+	// YAML is the content of the block, without the "---" and "..." markers
+	// and with the block indentation removed.
+	YAML string
+	// Indent is the indentation of the block markers.
+	Indent int
+	// Unterminated is set if the input ended, or the subtest of the block
+	// ended, before the "..." marker.
+	Unterminated bool
+	// Offsets are the byte offsets of the starts of the lines of Text.
+	Offsets []int64
+}
+
+// lineOffset returns the byte offset of the line k of the block, where the
+// "---" marker is the line 0.
+func (e Diagnostic) lineOffset(k int) int64 {
+	if k < len(e.Offsets) {
+		return e.Offsets[k]
+	}
+	return e.Offset
+}
+
+// Comment is a comment line.
+// "# some comment"
+type Comment struct {
+	Token
+}
+
+// Subtest starts a TAP 14 subtest.  The events of the subtest follow, one
+// level deeper, until an event at a lower Depth.
+type Subtest struct {
+	Token
+	// Name is the name from the "# Subtest: name" line, if any.
+	Name string
+}
+
+// BailOut describes an aborted test run.
+// "Bail out! some reason"
+type BailOut struct {
+	Token
+	// Reason is the text following "Bail out!", if any.
+	Reason string
+}
+
+// Unknown is a line that is not TAP, such as the output of the program under
+// test.
+type Unknown struct {
+	Token
+}
+
+// subtestIndent is the indentation of a TAP 14 subtest.
+const subtestIndent = "    "
+
+// maxDepth is the deepest subtest read, to keep a deeply indented line from
+// opening a subtest for every four of its spaces.  The lines of deeper
+// subtests are read as lines that are not TAP.
+const maxDepth = 64
+
+var (
+	// subtestLine is the optional line that introduces a subtest.
+	// "# Subtest: name"
+	subtestLine = regexp.MustCompile(`^#\s+Subtest:\s*(.*)$`)
+
+	// yamlStart starts a YAML diagnostic block.
+	yamlStart = regexp.MustCompile(`^(\s+)---\s*$`)
+
+	// spec is the TAP line representing the version.
+	spec = regexp.MustCompile(`^TAP version (\d+)$`)
+
+	// pragmaLine turns a parsing behavior on or off.
+	pragmaLine = regexp.MustCompile(`^pragma\s+([+-])([a-zA-Z0-9_-]+)\s*$`)
+
+	// planLine is the range of the tests to run.
+	planLine = regexp.MustCompile(`^(\d+)\.\.(\d+)\s*(#\s*(.*))?$`)
+
+	// skipAll is the directive of an empty plan.
+	skipAll = regexp.MustCompile(`(?i)^skip\S*\s*(.*)`)
+
+	// bailOutLine aborts the test run.
+	bailOutLine = regexp.MustCompile(`Bail out!\s*(.*)`)
+)
+
+// parserLevel is the status of a subtest nesting level of the Parser.
+type parserLevel struct {
+	// tests is set once a test point was read at this level.
+	tests bool
+	// fresh is set for a subtest that was started by its indentation, and
+	// has no lines yet.
+	fresh bool
+}
+
+// Parser reads a TAP stream, and emits its elements as events as soon as they
+// are read.  Unlike Read, it keeps no results: it only tracks the subtest
+// nesting and the YAML diagnostic blocks.
+type Parser struct {
+	// MaxLineLength is the longest input line kept, in bytes.  The lines that
+	// are longer are cut short and marked as truncated.  If 0, lines can be
+	// of any length.
+	MaxLineLength int
+
+	r *bufio.Reader
+	// src counts the bytes read from the input, after dec.
+	src  *countingReader
+	dec  *decoder
+	line int
+	// lineStart and lineEnd are the byte offsets of the line being read, and
+	// past its line ending.
+	lineStart, lineEnd int64
+	// truncated, crlf and invalid are the Token flags of the line being read.
+	truncated, crlf, invalid bool
+	// encoding is what was found out about the input so far.
+	encoding Encoding
+	// buf holds the line being read, if it did not fit the buffer of r.
+	buf []byte
+	// err is the error that ended the input, if it is not io.EOF.
+	err error
+	// levels are the open subtest levels, levels[0] is the top level.
+	levels []parserLevel
+	// yaml is the YAML diagnostic block being read, nil if not in a block.
+	yaml *Diagnostic
+	// yamlLines and yamlText are the content and the input lines of yaml.
+	yamlLines, yamlText []string
+	// yamlOffsets are the offsets of yamlText.
+	yamlOffsets []int64
+
+	// unnamed is the subtest started by its indentation, which is not
+	// emitted until it is known whether a "# Subtest:" line names it.
+	unnamed *Subtest
+	// held is a "# Subtest:" line at the start of unnamed.  It names unnamed,
+	// unless the next line starts a nested subtest, which it names instead.
+	held *Subtest
+	// queue are the events read, and next is the first of them that was not
+	// returned yet.
+	queue []Event
+	next  int
+	done  bool
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewParser returns a Parser reading the TAP stream from r.
+func NewParser(r io.Reader) *Parser {
+	dec := newDecoder(r)
+	src := &countingReader{r: dec}
+	return &Parser{
+		r:      bufio.NewReader(src),
+		src:    src,
+		dec:    dec,
+		levels: []parserLevel{{}},
+	}
+}
+
+// token returns the token of the line being read, with the text t at the
+// subtest depth d.
+func (p *Parser) token(d int, t string) Token {
+	return Token{
+		Line:        p.line,
+		EndLine:     p.line,
+		Offset:      p.lineStart,
+		EndOffset:   p.lineEnd,
+		Depth:       d,
+		Text:        t,
+		Truncated:   p.truncated,
+		CRLF:        p.crlf,
+		InvalidUTF8: p.invalid,
+	}
+}
+
+// Encoding returns how the input read so far was encoded, and what was
+// changed to read it.
+func (p *Parser) Encoding() Encoding {
+	e := p.encoding
+	e.Charset = p.dec.charset
+	e.BOM = p.dec.bom
+	return e
+}
+
+// Next returns the next event in the stream.  It returns io.EOF at the end of
+// the stream.
+func (p *Parser) Next() (Event, error) {
+	for p.next == len(p.queue) {
+		p.queue, p.next = p.queue[:0], 0
+		if p.done {
+			if p.err != nil {
+				return nil, p.err
+			}
+			return nil, io.EOF
+		}
+		t, err := p.readLine()
+		if err != nil {
+			if err != io.EOF {
+				p.err = err
+			}
+			p.done = true
+			p.end()
+			continue
+		}
+		p.line++
+		p.read(t)
+	}
+	e := p.queue[p.next]
+	p.queue[p.next] = nil
+	p.next++
+	return e, nil
+}
+
+// readLine reads the next input line, without the line ending.  A line longer
+// than MaxLineLength is cut short, at the start of a UTF-8 character.  The
+// byte order mark is removed from the first line, and invalid UTF-8 is
+// replaced.
+func (p *Parser) readLine() (string, error) {
+	p.truncated, p.crlf, p.invalid = false, false, false
+	p.lineStart = p.lineEnd
+	l, more, err := p.r.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	// size is the length of the line as read, without the line ending.
+	size := int64(len(l))
+	defer func() {
+		// What was read, less what is still buffered, is where the line
+		// ends.
+		p.lineEnd = p.src.n - int64(p.r.Buffered())
+		if p.lineEnd-p.lineStart == size+2 {
+			p.crlf = true
+			p.encoding.CRLF = true
+		}
+	}()
+	if more {
+		// The line does not fit the buffer of r, collect it in pieces.
+		p.buf = append(p.buf[:0], l...)
+		for more {
+			l, more, err = p.r.ReadLine()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			size += int64(len(l))
+			if p.MaxLineLength > 0 && len(p.buf) > p.MaxLineLength {
+				// Skip the rest of the line.
+				continue
+			}
+			p.buf = append(p.buf, l...)
+		}
+		l = p.buf
+	}
+	if p.MaxLineLength > 0 && len(l) > p.MaxLineLength {
+		n := p.MaxLineLength
+		for n > 0 && !utf8.RuneStart(l[n]) {
+			n--
+		}
+		l = l[:n]
+		p.truncated = true
+	}
+	if p.line == 0 {
+		l = bytes.TrimPrefix(l, utf8BOM)
+	}
+	if len(l) > 0 && l[len(l)-1] == '\r' {
+		// ReadLine keeps the "\r" of the last line, if it has no "\n".
+		l = l[:len(l)-1]
+	}
+	if !utf8.Valid(l) {
+		p.invalid = true
+		p.encoding.InvalidUTF8 = true
+		return strings.ToValidUTF8(string(l), string(utf8.RuneError)), nil
+	}
+	return string(l), nil
+}
+
+// Parse calls fn with each event in the stream, in order.  It stops at the end
+// of the stream, or at the first error returned by fn, which it returns.
+func (p *Parser) Parse(fn func(Event) error) error {
+	for {
+		e, err := p.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *Parser) emit(e Event) {
+	if glog.V(2) {
+		glog.Infof("event: %v", spew.Sdump(e))
+	}
+	p.queue = append(p.queue, e)
+}
+
+// read reads the input line t.
+func (p *Parser) read(t string) {
+	if glog.V(2) {
+		glog.Infof("Text: %q", t)
+	}
+	if p.yaml != nil && p.readYAML(t) {
+		return
+	}
+	if strings.TrimSpace(t) == "" {
+		return
+	}
+	if p.held != nil {
+		p.resolveHeld(t)
+	}
+	// The subtests end at the first line that is not indented for them.
+	d := min(len(p.levels)-1, indentation(t)/len(subtestIndent))
+	p.levels = p.levels[:d+1]
+	p.readAt(t[d*len(subtestIndent):], d)
+}
+
+// indentation returns the number of leading spaces of t.
+func indentation(t string) int {
+	return len(t) - len(strings.TrimLeft(t, " "))
+}
+
+// dedent removes n leading spaces from t, if t has that many.
+func dedent(t string, n int) string {
+	if indentation(t) < n {
+		return t
+	}
+	return t[n:]
+}
+
+// readYAML reads the line t of a YAML diagnostic block, and returns false if
+// t is not part of the block after all.
+func (p *Parser) readYAML(t string) bool {
+	d := p.yaml.Depth
+	if strings.TrimSpace(t) != "" && indentation(t) < d*len(subtestIndent) {
+		// The subtest ended in the middle of the block.
+		p.endYAML(true)
+		return false
+	}
+	t = dedent(t, d*len(subtestIndent))
+	p.yamlText = append(p.yamlText, t)
+	p.yamlOffsets = append(p.yamlOffsets, p.lineStart)
+	p.yaml.EndLine, p.yaml.EndOffset = p.line, p.lineEnd
+	p.yaml.Truncated = p.yaml.Truncated || p.truncated
+	p.yaml.CRLF = p.yaml.CRLF || p.crlf
+	p.yaml.InvalidUTF8 = p.yaml.InvalidUTF8 || p.invalid
+	if strings.TrimSpace(t) == "..." {
+		p.endYAML(false)
+		return true
+	}
+	l := strings.TrimRight(t, " \t")
+	p.yamlLines = append(p.yamlLines, dedent(l, p.yaml.Indent))
+	return true
+}
+
+func (p *Parser) endYAML(unterminated bool) {
+	y := p.yaml
+	y.Text = strings.Join(p.yamlText, "\n")
+	y.YAML = strings.Join(p.yamlLines, "\n")
+	y.Unterminated = unterminated
+	y.Offsets = p.yamlOffsets
+	p.yaml, p.yamlLines, p.yamlText, p.yamlOffsets = nil, nil, nil, nil
+	p.emit(*y)
+}
+
+// flushUnnamed emits the subtest started by its indentation, if any.
+func (p *Parser) flushUnnamed() {
+	if p.unnamed == nil {
+		return
+	}
+	p.emit(*p.unnamed)
+	p.unnamed = nil
+}
+
+// resolveHeld decides what the held "# Subtest:" line names, based on the
+// next line t.
+func (p *Parser) resolveHeld(t string) {
+	h := p.held
+	p.held = nil
+	if indentation(t) >= (h.Depth+1)*len(subtestIndent) {
+		// "# Subtest:" introduces a nested subtest.
+		p.flushUnnamed()
+		p.levels = append(p.levels, parserLevel{})
+		h.Depth++
+		p.emit(*h)
+		return
+	}
+	p.unnamed.Name = h.Name
+	p.unnamed.Text = h.Text
+	p.flushUnnamed()
+}
+
+// matchIf matches t against re, but only if the cheaper check maybe shows that
+// t may match at all.
+func matchIf(maybe bool, re *regexp.Regexp, t string) []string {
+	if !maybe {
+		return nil
+	}
+	return re.FindStringSubmatch(t)
+}
+
+// readAt reads the line t at the subtest depth d.  t has the subtest
+// indentation removed.
+func (p *Parser) readAt(t string, d int) {
+	tok := p.token(d, t)
+
+	if v := matchIf(p.levels[d].tests && strings.Contains(t, "---"), yamlStart, t); v != nil {
+		p.flushUnnamed()
+		p.yaml = &Diagnostic{Token: tok, Indent: len(v[1])}
+		p.yamlText = []string{t}
+		p.yamlOffsets = []int64{p.lineStart}
+		p.yamlLines = []string{}
+		return
+	}
+
+	// "    1..2", a subtest without a "# Subtest:" line.
+	if d < maxDepth && strings.HasPrefix(t, subtestIndent) {
+		p.flushUnnamed()
+		p.levels[d].fresh = false
+		p.levels = append(p.levels, parserLevel{fresh: true})
+		p.unnamed = &Subtest{Token: p.token(d+1, "")}
+		p.readAt(t[len(subtestIndent):], d+1)
+		return
+	}
+
+	fresh := p.levels[d].fresh
+	p.levels[d].fresh = false
+	if v := matchIf(d < maxDepth && strings.HasPrefix(t, "#"), subtestLine, t); v != nil {
+		if fresh && p.unnamed != nil {
+			p.held = &Subtest{Token: tok, Name: v[1]}
+			return
+		}
+		p.flushUnnamed()
+		p.levels = append(p.levels, parserLevel{})
+		tok.Depth++
+		p.emit(Subtest{Token: tok, Name: v[1]})
+		return
+	}
+	p.flushUnnamed()
+
+	if v := matchIf(strings.HasPrefix(t, "TAP version"), spec, t); v != nil {
+		p.emit(Version{Token: tok, Version: toInt(v[1])})
+		return
+	}
+	if v := matchIf(strings.HasPrefix(t, "pragma"), pragmaLine, t); v != nil {
+		p.emit(Pragma{Token: tok, Key: v[2], On: v[1] == "+"})
+		return
+	}
+	if v := matchIf(t != "" && t[0] >= '0' && t[0] <= '9', planLine, t); v != nil {
+		e := Plan{Token: tok, First: toInt(v[1]), Last: toInt(v[2])}
+		if e.Last == 0 {
+			e.Skip = true
+			if sv := skipAll.FindStringSubmatch(v[4]); sv != nil {
+				e.SkipReason = sv[1]
+			}
+		}
+		p.emit(e)
+		return
+	}
+	if e, ok := parseTestPoint(tok); ok {
+		p.levels[d].tests = true
+		p.emit(e)
+		return
+	}
+	if strings.HasPrefix(t, "#") {
+		p.emit(Comment{Token: tok})
+		return
+	}
+	if v := matchIf(strings.Contains(t, "Bail out!"), bailOutLine, t); v != nil {
+		p.emit(BailOut{Token: tok, Reason: v[1]})
+		return
+	}
+	p.emit(Unknown{Token: tok})
+}
+
+// spaces are the characters that "\s" matches in a regular expression.
+const spaces = " \t\n\f\r"
+
+// parseTestPoint parses a test point.  Save for the TAP 14 escaping of "#" and
+// "\" as "\#" and "\\", it is equivalent to matching the regular expression
+// below, but several times faster on large inputs.
+//
+//	^(ok|not ok) (\d+)?(\s+)?([^#]*)(#\s+(TODO|todo|SKIP|skip)?(.*))?
+//
+// "not ok 42 some test # SKIP some comment"
+func parseTestPoint(tok Token) (TestPoint, bool) {
+	e := TestPoint{Token: tok}
+	t := tok.Text
+	switch {
+	case strings.HasPrefix(t, "ok "):
+		e.OK = true
+		t = t[len("ok "):]
+	case strings.HasPrefix(t, "not ok "):
+		t = t[len("not ok "):]
+	default:
+		return e, false
+	}
+	i := 0
+	for i < len(t) && t[i] >= '0' && t[i] <= '9' {
+		i++
+	}
+	if i > 0 {
+		n := toInt(t[:i])
+		e.Number = &n
+	}
+	t = strings.TrimLeft(t[i:], spaces)
+	header, rest := cutComment(t)
+	e.Header = strings.Trim(header, " ")
+	e.Description = unescape(strings.TrimSpace(strings.TrimPrefix(e.Header, "-")))
+	if rest == "" || !strings.ContainsRune(spaces, rune(rest[0])) {
+		return e, true
+	}
+	rest = strings.TrimLeft(rest, spaces)
+	// The directive is the whole word, in any case: "# Skipped: reason".
+	word := rest
+	if i := strings.IndexAny(rest, spaces); i >= 0 {
+		word = rest[:i]
+	}
+	for _, d := range []string{"TODO", "SKIP"} {
+		if len(word) >= len(d) && strings.EqualFold(word[:len(d)], d) {
+			e.Directive = d
+			e.DirectiveReason = unescape(strings.TrimSpace(rest[len(word):]))
+			break
+		}
+	}
+	return e, true
+}
+
+// cutComment cuts t around its first "#" that is not escaped as "\#".
+func cutComment(t string) (before, after string) {
+	for i := 0; i < len(t); i++ {
+		switch t[i] {
+		case '\\':
+			// Skip the escaped character.
+			i++
+		case '#':
+			return t[:i], t[i+1:]
+		}
+	}
+	return t, ""
+}
+
+// unescape replaces the TAP 14 escapes "\#" and "\\" in t with the characters
+// they stand for.  Other backslashes are kept as they are.
+func unescape(t string) string {
+	if !strings.Contains(t, "\\") {
+		return t
+	}
+	var b strings.Builder
+	for i := 0; i < len(t); i++ {
+		if t[i] == '\\' && i+1 < len(t) && (t[i+1] == '#' || t[i+1] == '\\') {
+			i++
+		}
+		b.WriteByte(t[i])
+	}
+	return b.String()
+}
+
+// end completes the events in progress at the end of the stream.
+func (p *Parser) end() {
+	if p.yaml != nil {
+		p.endYAML(true)
+	}
+	if p.held != nil {
+		p.resolveHeld("")
+	}
+	p.flushUnnamed()
+}
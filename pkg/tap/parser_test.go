@@ -0,0 +1,396 @@
+package tap
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParser(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []Event
+	}{
+		{
+			name: "Basic",
+			input: `TAP version 13
+1..2
+# first comes first
+ok 1 - first
+not ok 2 second # TODO later
+some output
+Bail out! Enough.
+`,
+			expected: []Event{
+				Version{Token: Token{Line: 1, Text: "TAP version 13"}, Version: 13},
+				Plan{Token: Token{Line: 2, Text: "1..2"}, First: 1, Last: 2},
+				Comment{Token: Token{Line: 3, Text: "# first comes first"}},
+				TestPoint{
+					Token:       Token{Line: 4, Text: "ok 1 - first"},
+					OK:          true,
+					Number:      ptr(1),
+					Header:      "- first",
+					Description: "first",
+				},
+				TestPoint{
+					Token:           Token{Line: 5, Text: "not ok 2 second # TODO later"},
+					Number:          ptr(2),
+					Header:          "second",
+					Description:     "second",
+					Directive:       "TODO",
+					DirectiveReason: "later",
+				},
+				Unknown{Token: Token{Line: 6, Text: "some output"}},
+				BailOut{Token: Token{Line: 7, Text: "Bail out! Enough."}, Reason: "Enough."},
+			},
+		},
+		{
+			name: "Pragmas",
+			input: `pragma +strict
+pragma -tap2junit-reorder-all
+pragma strict
+`,
+			expected: []Event{
+				Pragma{Token: Token{Line: 1, Text: "pragma +strict"}, Key: "strict", On: true},
+				Pragma{Token: Token{Line: 2, Text: "pragma -tap2junit-reorder-all"}, Key: "tap2junit-reorder-all"},
+				Unknown{Token: Token{Line: 3, Text: "pragma strict"}},
+			},
+		},
+		{
+			name: "Skip all",
+			input: `1..0 # Skipped: no database
+`,
+			expected: []Event{
+				Plan{
+					Token:      Token{Line: 1, Text: "1..0 # Skipped: no database"},
+					First:      1,
+					Skip:       true,
+					SkipReason: "no database",
+				},
+			},
+		},
+		{
+			name: "Diagnostics",
+			input: `ok 1
+  ---
+  message: fine
+
+  ...
+not ok 2
+  ---
+  message: unterminated
+`,
+			expected: []Event{
+				TestPoint{Token: Token{Line: 1, Text: "ok 1"}, OK: true, Number: ptr(1)},
+				Diagnostic{
+					Token:  Token{Line: 2, Text: "  ---\n  message: fine\n\n  ..."},
+					YAML:   "message: fine\n",
+					Indent: 2,
+				},
+				TestPoint{Token: Token{Line: 6, Text: "not ok 2"}, Number: ptr(2)},
+				Diagnostic{
+					Token:        Token{Line: 7, Text: "  ---\n  message: unterminated"},
+					YAML:         "message: unterminated",
+					Indent:       2,
+					Unterminated: true,
+				},
+			},
+		},
+		{
+			name: "Subtests",
+			input: `# Subtest: first
+    # Subtest: nested
+        ok 1
+          ---
+          at: here
+    ok 1 - nested
+ok 1 - first
+    # Subtest: second
+    ok 1
+ok 2 - second
+        ok 1
+ok 3
+`,
+			expected: []Event{
+				Subtest{Token: Token{Line: 1, Depth: 1, Text: "# Subtest: first"}, Name: "first"},
+				Subtest{Token: Token{Line: 2, Depth: 2, Text: "# Subtest: nested"}, Name: "nested"},
+				TestPoint{Token: Token{Line: 3, Depth: 2, Text: "ok 1"}, OK: true, Number: ptr(1)},
+				Diagnostic{
+					Token:        Token{Line: 4, Depth: 2, Text: "  ---\n  at: here"},
+					YAML:         "at: here",
+					Indent:       2,
+					Unterminated: true,
+				},
+				TestPoint{
+					Token:       Token{Line: 6, Depth: 1, Text: "ok 1 - nested"},
+					OK:          true,
+					Number:      ptr(1),
+					Header:      "- nested",
+					Description: "nested",
+				},
+				TestPoint{
+					Token:       Token{Line: 7, Text: "ok 1 - first"},
+					OK:          true,
+					Number:      ptr(1),
+					Header:      "- first",
+					Description: "first",
+				},
+				Subtest{Token: Token{Line: 8, Depth: 1, Text: "# Subtest: second"}, Name: "second"},
+				TestPoint{Token: Token{Line: 9, Depth: 1, Text: "ok 1"}, OK: true, Number: ptr(1)},
+				TestPoint{
+					Token:       Token{Line: 10, Text: "ok 2 - second"},
+					OK:          true,
+					Number:      ptr(2),
+					Header:      "- second",
+					Description: "second",
+				},
+				Subtest{Token: Token{Line: 11, Depth: 1}},
+				Subtest{Token: Token{Line: 11, Depth: 2}},
+				TestPoint{Token: Token{Line: 11, Depth: 2, Text: "ok 1"}, OK: true, Number: ptr(1)},
+				TestPoint{Token: Token{Line: 12, Text: "ok 3"}, OK: true, Number: ptr(3)},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			var actual []Event
+			err := NewParser(strings.NewReader(test.input)).Parse(func(e Event) error {
+				actual = append(actual, e)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// The positions are checked by TestParserPositions.
+			opts := cmp.Options{
+				cmpopts.IgnoreFields(Token{}, "EndLine", "Offset", "EndOffset"),
+				cmpopts.IgnoreFields(Diagnostic{}, "Offsets"),
+			}
+			if !cmp.Equal(test.expected, actual, opts) {
+				t.Errorf("diff:\n%v", cmp.Diff(test.expected, actual, opts))
+			}
+		})
+	}
+}
+
+func TestParserPositions(t *testing.T) {
+	input := "TAP version 14\r\n" + // 0
+		"ok 1 - first\n" + // 16
+		"  ---\n" + // 29
+		"  a: b\n" + // 35
+		"\n" + // 42
+		"  ...\n" + // 43
+		"# Subtest: sub\n" + // 49
+		"    ok 1\n" + // 64
+		"ok 2 - sub\n" + // 73
+		"Bail out!" // 84
+	var actual []Token
+	err := NewParser(strings.NewReader(input)).Parse(func(e Event) error {
+		tok := e.Input()
+		tok.Text = ""
+		actual = append(actual, tok)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Token{
+		{Line: 1, EndLine: 1, Offset: 0, EndOffset: 16, CRLF: true},
+		{Line: 2, EndLine: 2, Offset: 16, EndOffset: 29},
+		{Line: 3, EndLine: 6, Offset: 29, EndOffset: 49},
+		{Line: 7, EndLine: 7, Offset: 49, EndOffset: 64, Depth: 1},
+		{Line: 8, EndLine: 8, Offset: 64, EndOffset: 73, Depth: 1},
+		{Line: 9, EndLine: 9, Offset: 73, EndOffset: 84},
+		{Line: 10, EndLine: 10, Offset: 84, EndOffset: 93},
+	}
+	if !cmp.Equal(expected, actual) {
+		t.Errorf("diff:\n%v", cmp.Diff(expected, actual))
+	}
+}
+
+func TestParserDepth(t *testing.T) {
+	input := strings.Repeat(" ", 6400) + "ok 1\n"
+	var depth, unknown int
+	err := NewParser(strings.NewReader(input)).Parse(func(e Event) error {
+		depth = max(depth, e.Input().Depth)
+		if _, ok := e.(Unknown); ok {
+			unknown++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if depth != maxDepth || unknown != 1 {
+		t.Errorf("got depth %v and %v unknown lines, expected %v and 1", depth, unknown, maxDepth)
+	}
+}
+
+func TestParserStreaming(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	p := NewParser(r)
+
+	// Each event is available as soon as its line is written, while the
+	// stream is still open.
+	for _, l := range []string{"1..3", "ok 1 - first", "not ok 2 - second"} {
+		go func() { io.WriteString(w, l+"\n") }()
+		e, err := p.Next()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if e.Input().Text != l {
+			t.Errorf("got event %+v, expected one for %q", e, l)
+		}
+	}
+}
+
+func TestParserStop(t *testing.T) {
+	stop := errors.New("stop")
+	var n int
+	err := NewParser(strings.NewReader("ok 1\nnot ok 2\nok 3\n")).Parse(func(e Event) error {
+		n++
+		if tp, ok := e.(TestPoint); ok && !tp.OK {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("got error %v, expected %v", err, stop)
+	}
+	if n != 2 {
+		t.Errorf("got %v events, expected 2", n)
+	}
+}
+
+func TestTestPointEscaping(t *testing.T) {
+	tests := []struct {
+		line            string
+		description     string
+		directive       string
+		directiveReason string
+	}{
+		{
+			line:        `ok 3 handles \# in issue titles`,
+			description: `handles # in issue titles`,
+		},
+		{
+			line:        `ok 1 - a \\ backslash`,
+			description: `a \ backslash`,
+		},
+		{
+			line:        `ok 1 - ends with a backslash \\`,
+			description: `ends with a backslash \`,
+		},
+		{
+			line:        `ok 1 - \\# the hash after an escaped backslash is a comment`,
+			description: `\`,
+		},
+		{
+			line:            `not ok 1 - issue \#42 # TODO fix \# and \\ in reasons`,
+			description:     `issue #42`,
+			directive:       "TODO",
+			directiveReason: `fix # and \ in reasons`,
+		},
+		{
+			line:            `ok 1 - \# SKIP is not a directive # skip but this is`,
+			description:     `# SKIP is not a directive`,
+			directive:       "SKIP",
+			directiveReason: "but this is",
+		},
+		{
+			line:            `ok 1 - a # skipped: because`,
+			description:     `a`,
+			directive:       "SKIP",
+			directiveReason: "because",
+		},
+		{
+			line:            `ok 1 - a # Skip reason`,
+			description:     `a`,
+			directive:       "SKIP",
+			directiveReason: "reason",
+		},
+		{
+			line:        `not ok 1 - a # Todo`,
+			description: `a`,
+			directive:   "TODO",
+		},
+		{
+			line:        `ok 1 - other escapes \n \t stay`,
+			description: `other escapes \n \t stay`,
+		},
+		{
+			line:        `ok 1 - a trailing backslash \`,
+			description: `a trailing backslash \`,
+		},
+	}
+	for _, test := range tests {
+		e, ok := parseTestPoint(Token{Text: test.line})
+		if !ok {
+			t.Errorf("parseTestPoint(%q): not a test point", test.line)
+			continue
+		}
+		actual := []string{e.Description, e.Directive, e.DirectiveReason}
+		expected := []string{test.description, test.directive, test.directiveReason}
+		if !cmp.Equal(expected, actual) {
+			t.Errorf("parseTestPoint(%q) diff:\n%v", test.line, cmp.Diff(expected, actual))
+		}
+	}
+}
+
+// testLine is the regular expression that parseTestPoint replaces.
+var testLine = regexp.MustCompile(
+	`(?s)^(ok|not ok)( (\d+)?(\s+)?(([^#]*))?(#\s+((?:[Tt][Oo][Dd][Oo]|[Ss][Kk][Ii][Pp])\S*)?(.*))?)`)
+
+func FuzzParseTestPoint(f *testing.F) {
+	for _, seed := range []string{
+		"ok 1 - fine",
+		"not ok 2 broken # TODO later",
+		"ok  3 # skip",
+		"ok 4a #no directive",
+		"ok # SKIPPED: reason",
+		"ok 1 - a # skipped: because",
+		"ok 1 - a # Skip reason",
+		"not ok 1 - a # ToDo: later",
+		"not ok\t5",
+		"okay",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		if strings.Contains(line, `\`) {
+			// The regular expression knows nothing of escaping.
+			t.Skip()
+		}
+		e, ok := parseTestPoint(Token{Text: line})
+		v := testLine.FindStringSubmatch(line)
+		if ok != (v != nil) {
+			t.Fatalf("parseTestPoint(%q) = _, %v, regexp match: %q", line, ok, v)
+		}
+		if !ok {
+			return
+		}
+		expected := TestPoint{Token: Token{Text: line}, OK: v[1] == "ok"}
+		if v[3] != "" {
+			n := toInt(v[3])
+			expected.Number = &n
+		}
+		expected.Header = strings.Trim(v[5], " ")
+		expected.Description = strings.TrimSpace(strings.TrimPrefix(expected.Header, "-"))
+		if v[8] != "" {
+			expected.Directive = strings.ToUpper(v[8][:4])
+			expected.DirectiveReason = strings.TrimSpace(v[9])
+		}
+		if !cmp.Equal(expected, e) {
+			t.Errorf("parseTestPoint(%q) diff:\n%v", line, cmp.Diff(expected, e))
+		}
+	})
+}
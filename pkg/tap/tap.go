@@ -3,19 +3,19 @@
 package tap
 
 import (
-	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/golang/glog"
 )
 
-//d Status is the test status type.
+// Status is the test status type.  It is written as its name in text and in
+// JSON, such as "passed".
 type Status int
 
 const (
@@ -31,16 +31,115 @@ const (
 	TODO = Status(4)
 )
 
+var statusNames = map[Status]string{
+	UNKNOWN: "unknown",
+	PASSED:  "passed",
+	FAILED:  "failed",
+	SKIPPED: "skipped",
+	TODO:    "todo",
+}
+
+// String implements fmt.Stringer.
+func (s Status) String() string {
+	if n, ok := statusNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Status) MarshalText() ([]byte, error) {
+	n, ok := statusNames[s]
+	if !ok {
+		return nil, fmt.Errorf("unknown status: %d", int(s))
+	}
+	return []byte(n), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Status) UnmarshalText(b []byte) error {
+	for k, v := range statusNames {
+		if v == string(b) {
+			*s = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown status: %q, want one of: unknown, passed, failed, skipped, todo", b)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.  It also reads the numbers that
+// a Status was written as before it had a name.
+func (s *Status) UnmarshalJSON(b []byte) error {
+	if n, err := strconv.Atoi(string(b)); err == nil {
+		if _, ok := statusNames[Status(n)]; !ok {
+			return fmt.Errorf("unknown status: %d", n)
+		}
+		*s = Status(n)
+		return nil
+	}
+	var t string
+	if err := json.Unmarshal(b, &t); err != nil {
+		return fmt.Errorf("invalid status: %s", b)
+	}
+	return s.UnmarshalText([]byte(t))
+}
+
 // Result is the result of a single TAP test.
 type Result struct {
 	// Status shows the status of this test.
 	Status Status
+	// OK is set if the test point was "ok", as opposed to "not ok".  This
+	// tells apart the TODO tests that unexpectedly passed.
+	OK bool
 	// Duration is how long the test took.
 	Duration time.Duration
 	// Header is the first line of the test, complete.
 	Header string
+	// Number is the test number, as reported or as implied by the position
+	// of the test.
+	Number int
+	// Description is the test description, without the customary "- "
+	// separator.
+	Description string
+	// Directive is the test directive, "SKIP" or "TODO", if any.
+	Directive string
+	// DirectiveReason is the explanation that follows the directive.
+	DirectiveReason string
 	// Raw is the raw content of the test result dump.
 	Raw string
+	// Output are the lines following the test that are not TAP, such as the
+	// output of the program under test.
+	Output string
+	// Diagnostics is the parsed TAP 13 YAML diagnostic block that followed
+	// the test line, if any.  Scalars are kept as strings, nested mappings
+	// as map[string]any and nested sequences as []any.
+	Diagnostics map[string]any
+	// YAML is the raw YAML diagnostic block, without the "---" and "..."
+	// markers and with the block indentation removed.
+	YAML string
+	// Subtest is the TAP 14 subtest that this test point summarizes, if any.
+	Subtest *Case
+	// Truncated is set if a line of the test was longer than
+	// ReadOpt.MaxLineLength, and was cut short.
+	Truncated bool
+
+	// The fields below are set by "# TAP2JUNIT:" annotations, see Directive.
+
+	// Classname is the test class, instead of the path of subtest names.
+	Classname string
+	// File and Line are where the test is defined.
+	File string
+	Line int
+	// Properties are the named values attached to the test.
+	Properties []Property
+	// Start and End are when the test started and ended, if known.
+	Start, End time.Time
+	// Attachments are the paths of the files that the test produced.
+	Attachments []string
+
+	// Span are the input lines of the test: its test point, and the
+	// diagnostics, annotations and output that belong to it.
+	Span Span
 }
 
 // Case is the result of running a TAP test suite.
@@ -60,6 +159,67 @@ type Case struct {
 	Raw string
 	// Duration is how long the test took, if known.
 	Duration time.Duration
+	// Start is when reading the test started, if ReadOpt.InferDurations was
+	// set.
+	Start time.Time
+	// Output are the annotations and the non-TAP lines that do not belong to
+	// any test, such as those before the first test.
+	Output string
+	// Missing are the numbers of the tests that reported no result: those of
+	// the plan, and those skipped over by the numbers of the reported tests.
+	// Results only has room for the tests up to the last one reported.
+	Missing []int
+	// Duplicates are the numbers of the tests that reported a result more
+	// than once.
+	Duplicates []int
+	// OutOfPlan are the numbers of the tests that reported a result, but are
+	// outside of the plan.
+	OutOfPlan []int
+	// BailOut is set if the test run was aborted with "Bail out!".
+	BailOut *BailOut
+	// Skipped is set if the plan was "1..0", meaning that the entire test was
+	// skipped.
+	Skipped bool
+	// SkipReason is the reason given in a "1..0 # SKIP reason" plan.
+	SkipReason string
+	// Warnings are the problems found in the input, if it was not read in
+	// strict mode.
+	Warnings []*ParseError
+	// Incomplete is set if reading stopped before the end of the input,
+	// because its context was done.  See ReadContext.
+	Incomplete bool
+	// Encoding is how the input of the test was encoded, and what was
+	// changed to read it.
+	Encoding Encoding
+	// Pragmas are the "pragma" lines of the test, in input order.
+	Pragmas []Pragma
+	// Plan is the plan line, if any.
+	Plan *Plan
+	// Comments are the comment lines of the test, annotations included, in
+	// input order.
+	Comments []Comment
+	// Dialect is the dialect that the test was read as.  It is Generic if
+	// ReadOpt.Dialect was Auto, and nothing gave the producer away.
+	Dialect Dialect
+}
+
+// ParseError is a problem found in the TAP input.
+type ParseError struct {
+	// Line is the number of the offending input line, starting at 1.
+	Line int
+	// Offset is the byte offset of the start of the offending input line.
+	Offset int64
+	// Column is the column where the problem is, starting at 1.
+	Column int
+	// Text is the offending input line.
+	Text string
+	// Reason explains the problem.
+	Reason string
+}
+
+// Error implements error.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d (byte %d), column %d: %s: %q", e.Line, e.Offset, e.Column, e.Reason, e.Text)
 }
 
 // toInt parses a string to int.  The string is known to be parseable to int.
@@ -72,9 +232,9 @@ func copyResize(dest *[]Result, newSize int) {
 	if len(*dest) >= newSize {
 		return
 	}
-	new := make([]Result, newSize)
-	copy(*dest, new)
-	*dest = new
+	// Appending grows the capacity geometrically, so that adding the results
+	// one by one takes linear time.
+	*dest = append(*dest, make([]Result, newSize-len(*dest))...)
 }
 
 // StatusFrom returns a Status from a supplied string.
@@ -92,17 +252,648 @@ func StatusFrom(str string, def Status) Status {
 	return def
 }
 
-// parser contains the parser status
-type parser struct {
+// reader contains the status of reading a single Case, which is either the
+// top level test or a subtest.
+type reader struct {
+	r *Case
+	// raw collects the raw contents of r.
+	raw strings.Builder
 	// last test read
 	lt int
+	// child is the subtest that was read, waiting for its summarizing test
+	// point.
+	child *Case
+	// planned is set if a plan was read.
+	planned bool
+	// planFirst and planLast are the first and last test in the plan.
+	planFirst, planLast int
+	// seen counts the results reported for each test number.
+	seen map[int]int
+	// texts collect the Raw and the Output of the results by test number.
+	// They are set in the results once reading is complete.
+	texts map[int]*resultText
+	// output collects the Output of r.
+	output strings.Builder
+	// pending collects the reordered annotations for the next test.
+	pending *resultText
+	// pendingApply are the reordered annotations to apply to the next test.
+	pendingApply []func(*Result)
+	// pendingSpan are the input lines of pending.
+	pendingSpan Span
+	// content is set once a version, a plan or a test was read.
+	content bool
+	// trailingPlan is set if the plan followed the tests.
+	trailingPlan bool
+	// arrival is when the last test point, or the start of r, was read, if
+	// ReadOpt.InferDurations is set.
+	arrival time.Time
+}
+
+// resultText collects the text of a result while it is read, since appending
+// to its strings line by line would take quadratic time.
+type resultText struct {
+	raw, output strings.Builder
+}
+
+// appendLine appends the line l to b, joining it with "\n" to what b has.
+func appendLine(b *strings.Builder, l string) {
+	if b.Len() > 0 {
+		b.WriteByte('\n')
+	}
+	b.WriteString(l)
+}
+
+// text returns the text of the result with test number n.
+func (rd *reader) text(n int) *resultText {
+	if rd.texts == nil {
+		rd.texts = map[int]*resultText{}
+	}
+	t := rd.texts[n]
+	if t == nil {
+		t = &resultText{}
+		rd.texts[n] = t
+	}
+	return t
+}
+
+// flush sets the collected text into r and its results.
+func (rd *reader) flush() {
+	for n, t := range rd.texts {
+		res := &rd.r.Results[n-1]
+		res.Raw = t.raw.String()
+		res.Output = t.output.String()
+	}
+	rd.r.Output = rd.output.String()
+	rd.r.Raw = rd.raw.String()
+}
+
+// readState builds the Case from the events of a Parser.
+type readState struct {
+	opt ReadOpt
+	// levels are the cases being read, levels[0] is the top level and the
+	// others are the open subtests.
+	levels []*reader
+	// line and offset are the number and the byte offset of the last input
+	// line read.
+	line   int
+	offset int64
+	// strict is set by "pragma +strict": the lines that are not TAP are
+	// errors until "pragma -strict".
+	strict bool
+	// reorderDuration and reorderAll start as in opt, and are turned on and
+	// off by the dialect and the pragmas.
+	reorderDuration, reorderAll bool
+	// dialect is the dialect being read, Auto until it is detected.
+	dialect Dialect
+	// quirks are the workarounds for dialect.
+	quirks quirks
+	// parser is the Parser of the events, which finds out the encoding.
+	parser *Parser
+	// first is set for the first document of the input, which a byte order
+	// mark is at the start of.
+	first bool
+}
+
+// pragmas are the behaviors that "pragma" lines turn on and off, by key.  A
+// pragma applies from its line to the end of the document, subtests included.
+var pragmas = map[string]func(rs *readState, on bool){
+	// The key defined by the TAP specification.
+	"strict": func(rs *readState, on bool) { rs.strict = on },
+	// tap2junit's own keys, which work like the ReadOpt fields.
+	"tap2junit-reorder-duration": func(rs *readState, on bool) { rs.reorderDuration = on },
+	"tap2junit-reorder-all":      func(rs *readState, on bool) { rs.reorderAll = on },
+}
+
+// errBailedOut stops reading at a "Bail out!" line.
+var errBailedOut = errors.New("bailed out")
+
+// maxTestJump is how far past the last test reported a test number or a plan
+// may reach, to keep a garbled test number or plan from exhausting memory.
+// Results has room for all the tests up to the last one reported, and
+// Missing lists the planned ones that are not, so the tests that count up
+// one by one are never out of range.
+const maxTestJump = 1 << 20
+
+// current returns the result of the last test read, or nil if there is none.
+func (rd *reader) current() *Result {
+	if rd.lt < 1 || rd.lt > len(rd.r.Results) {
+		return nil
+	}
+	return &rd.r.Results[rd.lt-1]
+}
+
+// problem records a problem with the input line number l, which starts at the
+// byte offset off, into r.  In strict mode, the problem is returned as an
+// error instead.
+func (rs *readState) problem(r *Case, l int, off int64, col int, text, reason string) error {
+	e := &ParseError{Line: l, Offset: off, Column: col, Text: text, Reason: reason}
+	if rs.opt.Strict {
+		return e
+	}
+	glog.V(1).Infof("%v", e)
+	r.Warnings = append(r.Warnings, e)
+	return nil
+}
+
+// event reads the event e into the Case being built.
+func (rs *readState) event(e Event) error {
+	tok := e.Input()
+	rs.line, rs.offset = tok.EndLine, tok.Offset
+	if d, ok := e.(Diagnostic); ok && len(d.Offsets) > 0 {
+		rs.offset = d.Offsets[len(d.Offsets)-1]
+	}
+	d := tok.Depth
+	if _, ok := e.(Subtest); ok {
+		// The subtest starts in its parent.
+		d--
+	}
+	for len(rs.levels)-1 > d {
+		if err := rs.endSub(); err != nil {
+			return err
+		}
+	}
+	if !rs.opt.DiscardRaw {
+		rs.appendRaw(tok)
+	}
+	rd := rs.levels[len(rs.levels)-1]
+	r := rd.r
+	if rs.dialect == Auto {
+		if d, ok := detect(e); ok {
+			glog.V(2).Infof("line %d: detected dialect %v", tok.Line, d)
+			rs.setDialect(d)
+		}
+	}
+	if tok.Truncated {
+		defer rs.truncated(rd, tok)
+	}
+	rs.encoding(tok)
+
+	switch e := e.(type) {
+	case Subtest:
+		rd.content = true
+		sub := &reader{r: &Case{Version: r.Version, Name: e.Name}}
+		rs.levels = append(rs.levels, sub)
+		if rs.opt.InferDurations {
+			sub.arrival = time.Now()
+			sub.r.Start = sub.arrival
+		}
+	case Version:
+		rd.content = true
+		r.Version = e.Version
+	case Pragma:
+		return rs.pragma(rd, e)
+	case Plan:
+		rd.content = true
+		return rs.plan(rd, e)
+	case TestPoint:
+		rd.content = true
+		return rs.testPoint(rd, e)
+	case Diagnostic:
+		return rs.diagnostic(rd, e)
+	case Comment:
+		return rs.comment(rd, e)
+	case BailOut:
+		glog.V(3).Infof("Found bail out! text: %q", e.Reason)
+		for _, rd := range rs.levels {
+			b := e
+			rd.r.BailOut = &b
+		}
+		return errBailedOut
+	case Unknown:
+		if rs.strict {
+			return &ParseError{Line: tok.Line, Offset: tok.Offset, Column: 1, Text: tok.Text, Reason: "not a TAP line in a strict region"}
+		}
+		if err := rs.problem(r, tok.Line, tok.Offset, 1, tok.Text, "not a TAP line"); err != nil {
+			return err
+		}
+		// Keep it as the output of the current test.
+		if res := rd.current(); res != nil {
+			res.Span.add(tok.Span())
+			appendLine(&rd.text(rd.lt).output, tok.Text)
+		} else {
+			appendLine(&rd.output, tok.Text)
+		}
+	}
+	return nil
+}
+
+// pragma turns the behavior named by e on or off.
+func (rs *readState) pragma(rd *reader, e Pragma) error {
+	r := rd.r
+	r.Pragmas = append(r.Pragmas, e)
+	set, ok := pragmas[e.Key]
+	if !ok {
+		// The column of the "+" or "-".
+		col := strings.Index(e.Text, e.Key)
+		return rs.problem(r, e.Line, e.Offset, col, e.Text, fmt.Sprintf("unknown pragma: %q", e.Key))
+	}
+	set(rs, e.On)
+	return nil
+}
+
+// setDialect reads the rest of the input as the dialect d.
+func (rs *readState) setDialect(d Dialect) {
+	rs.dialect = d
+	rs.levels[0].r.Dialect = d
+	rs.quirks = dialects[d]
+	if rs.quirks.reorderDuration {
+		rs.reorderDuration = true
+	}
+}
+
+// encoding records how the line of tok was encoded, in the top level Case.
+func (rs *readState) encoding(tok Token) {
+	enc := &rs.levels[0].r.Encoding
+	e := rs.parser.Encoding()
+	enc.Charset = e.Charset
+	if rs.first {
+		enc.BOM = e.BOM
+	}
+	enc.CRLF = enc.CRLF || tok.CRLF
+	enc.InvalidUTF8 = enc.InvalidUTF8 || tok.InvalidUTF8
+}
+
+// truncated records that the line of tok was cut short.  This is not a problem
+// with the input, so it is only ever a warning.
+func (rs *readState) truncated(rd *reader, tok Token) {
+	e := &ParseError{
+		Line:   tok.Line,
+		Offset: tok.Offset,
+		Column: rs.opt.MaxLineLength + 1,
+		Text:   tok.Text,
+		Reason: fmt.Sprintf("line truncated to %d bytes", rs.opt.MaxLineLength),
+	}
+	glog.V(1).Infof("%v", e)
+	rd.r.Warnings = append(rd.r.Warnings, e)
+	if res := rd.current(); res != nil {
+		res.Truncated = true
+	}
+}
+
+// appendRaw appends the text of tok to the raw contents of the cases being
+// read, indented to the depth of each.
+func (rs *readState) appendRaw(tok Token) {
+	for i, rd := range rs.levels {
+		if i == tok.Depth {
+			rd.raw.WriteString(tok.Text)
+			rd.raw.WriteByte('\n')
+			continue
+		}
+		indent := strings.Repeat(subtestIndent, tok.Depth-i)
+		for _, l := range strings.Split(tok.Text, "\n") {
+			rd.raw.WriteString(indent)
+			rd.raw.WriteString(l)
+			rd.raw.WriteByte('\n')
+		}
+	}
+}
+
+// plan records the test plan e.
+func (rs *readState) plan(rd *reader, e Plan) error {
+	r := rd.r
+	if e.First-rd.lt > maxTestJump || e.Last-rd.lt > maxTestJump {
+		return rs.problem(r, e.Line, e.Offset, 1, e.Text, "plan out of range")
+	}
+	r.Plan = &e
+	f, l := e.First, e.Last
+	r.First = &f
+	r.Last = &l
+	rd.planned = true
+	rd.trailingPlan = rd.lt > 0
+	rd.planFirst, rd.planLast = f, l
+	if e.Skip {
+		r.Skipped = true
+		r.SkipReason = e.SkipReason
+	}
+	// The results grow as the tests report, the tests that do not are
+	// found missing at the end.
+	return nil
+}
+
+// testPoint records the test point e.
+func (rs *readState) testPoint(rd *reader, e TestPoint) error {
+	r := rd.r
+	raw := strings.TrimPrefix(e.Text, "not ok")
+	def := FAILED
+	if e.OK {
+		raw = strings.TrimPrefix(e.Text, "ok")
+		def = PASSED
+	}
+	n := rd.lt + 1
+	if e.Number != nil {
+		n = *e.Number
+	}
+	if n < 1 || n-rd.lt > maxTestJump {
+		col := len(e.Text) - len(raw) + 2
+		reason := fmt.Sprintf("test number out of range: %v", n)
+		if err := rs.problem(r, e.Line, e.Offset, col, e.Text, reason); err != nil {
+			return err
+		}
+		n = rd.lt + 1
+	}
+	rd.lt = n
+	if rd.seen == nil {
+		rd.seen = map[int]int{}
+	}
+	rd.seen[rd.lt]++
+	copyResize(&r.Results, rd.lt)
+	if r.Last == nil || *r.Last < rd.lt {
+		l := rd.lt
+		r.Last = &l
+	}
+	res := &r.Results[rd.lt-1]
+	res.Status = StatusFrom(e.Directive, def)
+	res.OK = e.OK
+	res.Span = e.Span()
+	t := rd.text(rd.lt)
+	if rd.pending != nil {
+		appendLine(&t.raw, rd.pending.raw.String())
+		for _, apply := range rd.pendingApply {
+			apply(res)
+		}
+		res.Span.add(rd.pendingSpan)
+		rd.pending, rd.pendingApply, rd.pendingSpan = nil, nil, Span{}
+	}
+	appendLine(&t.raw, raw)
+	res.Header = e.Header
+	res.Number = rd.lt
+	res.Description = e.Description
+	if rs.quirks.timingSuffix {
+		if desc, d, ok := cutTiming(res.Description); ok {
+			res.Description = desc
+			// A duration annotation is more precise.
+			if res.Duration == 0 {
+				res.Duration = d
+			}
+		}
+	}
+	res.Directive, res.DirectiveReason = e.Directive, e.DirectiveReason
+	if _, c := cutComment(e.Text); e.Directive == "" {
+		// "ok 1 - name # time=12ms"
+		if v := timeComment.FindStringSubmatch(strings.TrimSpace(c)); v != nil {
+			d, err := parseDuration(v[1])
+			if err != nil {
+				col := strings.LastIndex(e.Text, v[1]) + 1
+				if err := rs.problem(r, e.Line, e.Offset, col, e.Text, err.Error()); err != nil {
+					return err
+				}
+			} else {
+				res.Duration = d
+			}
+		}
+	}
+	rd.attachSub(res)
+	if rs.opt.InferDurations {
+		// The test ran since the test point before it, if nothing more precise
+		// is known.  A duration annotation that follows takes over.
+		now := time.Now()
+		if res.Duration == 0 {
+			res.Duration = now.Sub(rd.arrival)
+		}
+		rd.arrival = now
+	}
+	return nil
+}
+
+// diagnostic attaches the YAML diagnostic block e to the current test.
+func (rs *readState) diagnostic(rd *reader, e Diagnostic) error {
+	res := rd.current()
+	if res == nil {
+		// The parser only reads blocks after a test point.
+		appendLine(&rd.output, e.Text)
+		return nil
+	}
+	appendLine(&rd.text(rd.lt).raw, e.Text)
+	res.Span.add(e.Span())
+	res.YAML = e.YAML
+	if e.Unterminated {
+		if err := rs.problem(rd.r, e.Line, e.Offset, e.Indent+1, "---", "unterminated YAML diagnostic block"); err != nil {
+			return err
+		}
+	}
+	d, err := parseYAML(e.YAML)
+	if err != nil {
+		lines := strings.Split(e.YAML, "\n")
+		l, off, text := e.Line, e.Offset, ""
+		if ye, ok := err.(*yamlError); ok && ye.line <= len(lines) {
+			l, off = l+ye.line, e.lineOffset(ye.line)
+			text = strings.Repeat(" ", e.Indent) + lines[ye.line-1]
+		}
+		return rs.problem(rd.r, l, off, e.Indent+1, text, err.Error())
+	}
+	res.Diagnostics = d
+	if v, ok := d["duration_ms"]; ok {
+		s, _ := v.(string)
+		ms, err := parseMilliseconds(s)
+		if err != nil {
+			l, off, text := e.Line, e.Offset, ""
+			for i, y := range strings.Split(e.YAML, "\n") {
+				if strings.HasPrefix(y, "duration_ms:") {
+					l, off, text = l+i+1, e.lineOffset(i+1), strings.Repeat(" ", e.Indent)+y
+					break
+				}
+			}
+			return rs.problem(rd.r, l, off, e.Indent+1, text, err.Error())
+		}
+		res.Duration = ms
+	}
+	return nil
+}
+
+// comment attaches the comment e to the "current" test.  A "# TAP2JUNIT:"
+// annotation is applied to it by its Directive.
+func (rs *readState) comment(rd *reader, e Comment) error {
+	r := rd.r
+	r.Comments = append(r.Comments, e)
+	for _, p := range rs.quirks.suiteComments {
+		if strings.HasPrefix(e.Text, p) {
+			appendLine(&rd.output, e.Text)
+			return nil
+		}
+	}
+
+	// "# time=12ms", the duration of the test or subtest that ends.
+	if v := timeComment.FindStringSubmatch(strings.TrimSpace(strings.TrimPrefix(e.Text, "#"))); v != nil {
+		d, err := parseDuration(v[1])
+		if err != nil {
+			col := strings.LastIndex(e.Text, v[1]) + 1
+			if err := rs.problem(r, e.Line, e.Offset, col, e.Text, err.Error()); err != nil {
+				return err
+			}
+		} else {
+			r.Duration = d
+		}
+	}
+
+	// Extension parsing
+	var (
+		name  string
+		apply func(*Result)
+	)
+	if line, ok := strings.CutPrefix(e.Text, "# TAP2JUNIT:"); ok {
+		name, line, _ = strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		line = strings.TrimSpace(line)
+		if glog.V(2) {
+			glog.Infof("extension: %q: %q", name, line)
+		}
+		d, ok := directive(name)
+		if !ok {
+			col := strings.Index(e.Text, name) + 1
+			if err := rs.problem(r, e.Line, e.Offset, col, e.Text, fmt.Sprintf("unknown directive: %q", name)); err != nil {
+				return err
+			}
+		} else if a, err := d(line); err != nil {
+			col := strings.LastIndex(e.Text, line) + 1
+			if err := rs.problem(r, e.Line, e.Offset, col, e.Text, err.Error()); err != nil {
+				return err
+			}
+		} else {
+			apply = a
+		}
+	}
+	if glog.V(5) {
+		glog.Infof(
+			"rd=%+v\n len(r.Results)=%v, r.Results=%+v\nlt=%v\n\n",
+			rd, len(r.Results), r.Results, rd.lt,
+		)
+	}
+	// A reordered annotation waits for the next test.
+	if rs.reorderAll || (rs.reorderDuration && name == "Duration") {
+		if rd.pending == nil {
+			rd.pending = &resultText{}
+		}
+		if apply != nil {
+			rd.pendingApply = append(rd.pendingApply, apply)
+		}
+		rd.pendingSpan.add(e.Span())
+		appendLine(&rd.pending.raw, e.Text)
+		return nil
+	}
+	// Annotations that belong to no test at all are kept as the suite
+	// output.
+	res := rd.current()
+	if res == nil {
+		appendLine(&rd.output, e.Text)
+		return nil
+	}
+	if apply != nil {
+		apply(res)
+	}
+	res.Span.add(e.Span())
+	appendLine(&rd.text(rd.lt).raw, e.Text)
+	return nil
 }
 
-func joinNonempty(one, two string) string {
-	if one == "" {
-		return two
+// validatePlan checks the reported results against the plan, if there was
+// one.
+func (rd *reader) validatePlan() {
+	r := rd.r
+	last := len(r.Results)
+	if rd.planned && rd.planLast > last {
+		// The planned tests past the last one reported are all missing.
+		r.Missing = make([]int, 0, rd.planLast-last)
+		last = rd.planLast
+	}
+	for n := 1; n <= last; n++ {
+		c := rd.seen[n]
+		if c > 1 {
+			r.Duplicates = append(r.Duplicates, n)
+		}
+		inPlan := rd.planned && n >= rd.planFirst && n <= rd.planLast
+		if c == 0 && (inPlan || n <= len(r.Results)) {
+			r.Missing = append(r.Missing, n)
+		}
+		if c > 0 && rd.planned && !inPlan {
+			r.OutOfPlan = append(r.OutOfPlan, n)
+		}
 	}
-	return strings.Join([]string{one, two}, "\n")
+}
+
+// finish completes reading rd at the end of its input.
+func (rs *readState) finish(rd *reader) error {
+	if err := rs.orphan(rd); err != nil {
+		return err
+	}
+	if rd.pending != nil {
+		appendLine(&rd.output, rd.pending.raw.String())
+	}
+	rd.flush()
+	rd.validatePlan()
+	if rs.opt.InferDurations && rd.r.Duration == 0 {
+		rd.r.Duration = time.Since(rd.r.Start)
+	}
+	return nil
+}
+
+// orphan keeps the subtest that was read as a result of its own, if it has no
+// summarizing test point.
+func (rs *readState) orphan(rd *reader) error {
+	r := rd.r
+	if rd.child == nil {
+		return nil
+	}
+	c := rd.child
+	rd.child = nil
+	if r.BailOut == nil {
+		reason := fmt.Sprintf("subtest %q has no summarizing test point", c.Name)
+		if err := rs.problem(r, rs.line, rs.offset, 1, "", reason); err != nil {
+			return err
+		}
+	}
+	// The subtest takes the place of its test point, so that the next test
+	// does not take it over unnoticed.
+	rd.lt++
+	if rd.seen == nil {
+		rd.seen = map[int]int{}
+	}
+	rd.seen[rd.lt]++
+	copyResize(&r.Results, rd.lt)
+	if r.Last == nil || *r.Last < rd.lt {
+		l := rd.lt
+		r.Last = &l
+	}
+	r.Results[rd.lt-1] = Result{
+		Header:      c.Name,
+		Number:      rd.lt,
+		Description: c.Name,
+		Subtest:     c,
+	}
+	return nil
+}
+
+// endSub completes the innermost subtest, and keeps it until its summarizing
+// test point shows up.
+func (rs *readState) endSub() error {
+	rd := rs.levels[len(rs.levels)-1]
+	rs.levels = rs.levels[:len(rs.levels)-1]
+	if err := rs.finish(rd); err != nil {
+		return err
+	}
+	parent := rs.levels[len(rs.levels)-1]
+	parent.r.Warnings = append(parent.r.Warnings, rd.r.Warnings...)
+	// A subtest that was not summarized before the next one started.
+	if err := rs.orphan(parent); err != nil {
+		return err
+	}
+	parent.child = rd.r
+	return nil
+}
+
+// attachSub attaches the subtest that was read to its summarizing test point
+// r.
+func (rd *reader) attachSub(r *Result) {
+	if rd.child == nil {
+		return
+	}
+	if rd.child.Name == "" {
+		rd.child.Name = r.Description
+	}
+	if r.Duration == 0 {
+		r.Duration = rd.child.Duration
+	}
+	r.Subtest = rd.child
+	rd.child = nil
 }
 
 // ReadOpt is the set of options passed to configure the reader.
@@ -112,148 +903,157 @@ type ReadOpt struct {
 	// ReorderTimeout says the Duration line will be added to the next test
 	// instead of the current, to work around issue
 	// https://github.com/bats-core/bats-core/issues/187
+	//
+	// Deprecated: Use Dialect Bats, which also handles the other bats quirks.
 	ReorderDuration bool
 	// ReorderAll will reorder *all* annotation lines and attribute them to the
 	// next test, even though this is not correct TAP specification.
 	ReorderAll bool
+	// Dialect is the producer of the input, whose quirks are worked around.
+	// The zero value reads the input as specified.
+	Dialect Dialect
 	// SingleSuite will make test output be a single suite.
 	SingleSuite bool
+
+	// Strict makes Read return a *ParseError on the first problem with the
+	// input.  Otherwise, the problems are collected in Case.Warnings.  A
+	// "pragma -strict" line in the input does not turn it off.
+	Strict bool
+
+	// DiscardRaw leaves Case.Raw empty, to avoid keeping a copy of the whole
+	// input in memory.  Case.Warnings still keeps a ParseError for every
+	// line that is not TAP, such as the output of the program under test.
+	DiscardRaw bool
+
+	// MaxLineLength is the longest input line kept, in bytes.  The lines
+	// that are longer are cut short, and reported in Case.Warnings even in
+	// strict mode.  If 0, lines can be of any length.
+	MaxLineLength int
+
+	// InferDurations times the tests of a live stream as they are read: a
+	// test without a duration annotation took the time since the test point
+	// before it.  It also sets Case.Start.
+	InferDurations bool
+}
+
+// newReadState returns the state for reading a Case named name.
+func newReadState(opt ReadOpt, name string, p *Parser) *readState {
+	r := &Case{Version: 12, Name: name}
+	rs := &readState{
+		opt:             opt,
+		levels:          []*reader{{r: r}},
+		reorderDuration: opt.ReorderDuration,
+		reorderAll:      opt.ReorderAll,
+		dialect:         Auto,
+		parser:          p,
+	}
+	if opt.Dialect != Auto {
+		rs.setDialect(opt.Dialect)
+	}
+	if opt.InferDurations {
+		r.Start = time.Now()
+		rs.levels[0].arrival = r.Start
+	}
+	return rs
+}
+
+// close completes reading, and returns the Case that was read.
+func (rs *readState) close() (Case, error) {
+	r := rs.levels[0].r
+	// The input may have had no events at all.
+	rs.encoding(Token{})
+	for len(rs.levels) > 1 {
+		if err := rs.endSub(); err != nil {
+			return *r, err
+		}
+	}
+	if err := rs.finish(rs.levels[0]); err != nil {
+		return *r, err
+	}
+	return *r, nil
+}
+
+// newDocument returns true if the event e starts a new TAP document, after the
+// one read so far.  This is a version line after any content, a second plan,
+// or anything but a comment after a plan that followed the tests.
+func (rs *readState) newDocument(e Event) bool {
+	top := rs.levels[0]
+	if e.Input().Depth > 1 || (!top.content && top.r.BailOut == nil) {
+		return false
+	}
+	switch e := e.(type) {
+	case Version:
+		return e.Depth == 0
+	case Plan:
+		return e.Depth == 0 && (top.planned || top.r.BailOut != nil)
+	case TestPoint:
+		return e.Depth == 0 && top.trailingPlan
+	case Subtest:
+		return top.trailingPlan
+	}
+	return false
 }
 
 // Read parses the contents of i into a Result. name is a given test name.  If
 // reorder is set, the Duration line will be added to the next test instead of
 // the current, to work around issue
 // https://github.com/bats-core/bats-core/issues/187
+//
+// Read is built on the events of a Parser, which can be used directly to
+// follow a test run while it is in progress.
 func Read(i io.Reader, opt ReadOpt) (Case, error) {
-	var (
-		r  Case = Case{Version: 12, Name: opt.Name}
-		ps parser
-	)
-	s := bufio.NewScanner(i)
-	for s.Scan() {
-		t := s.Text()
-		r.Raw = fmt.Sprintf("%s%s\n", r.Raw, t)
-
-		glog.V(2).Infof("Text: %q", t)
-
-		// Spec is the TAP line representing the version.
-		// "TAP version 13"
-		var Spec = regexp.MustCompile(`TAP version (\d+)`)
-		if v := Spec.FindStringSubmatch(t); v != nil {
-			glog.V(2).Infof("version: %v", spew.Sdump(v))
-			r.Version = toInt(v[1])
-			continue
-		}
-
-		// Range is the range of the tests to run.
-		// "1..42"
-		var Range = regexp.MustCompile(`^(\d+)\.\.(\d+)$`)
-		if v := Range.FindStringSubmatch(t); v != nil {
-			glog.V(2).Infof("range: %v", spew.Sdump(v))
-			f := toInt(v[1])
-			r.First = &f
-			l := toInt(v[2])
-			r.Last = &l
-			// Resize the results array to fit.
-			copyResize(&r.Results, *r.Last)
-			continue
-		}
-
-		// OKTest is an OK test line.
-		// "ok 41 some text # TODO some comment"
-		var OKTest = regexp.MustCompile(
-			`^ok( (\d+)?(\s+)?(([^#]*))?(#\s+(TODO|todo|SKIP|skip)?(.*))?)`)
+	p := NewParser(i)
+	rs := newReadState(opt, opt.Name, p)
+	rs.first = true
+	p.MaxLineLength = opt.MaxLineLength
+	err := p.Parse(rs.event)
+	if err != nil && err != errBailedOut {
+		rs.levels[0].flush()
+		return *rs.levels[0].r, err
+	}
+	return rs.close()
+}
 
-		// "42 ok Some comment"
-		// Regex analysis using https://regex101.com
-		if v := OKTest.FindStringSubmatch(t); v != nil {
-			glog.V(2).Infof("ok: %v", spew.Sdump(v))
-			tiStr := v[2]
-			if tiStr != "" {
-				ps.lt = toInt(tiStr)
-			} else {
-				ps.lt++
-			}
-			copyResize(&r.Results, ps.lt)
-			if r.Last == nil || *r.Last < ps.lt {
-				l := ps.lt
-				r.Last = &l
+// ReadAll parses the contents of i, which may be several TAP documents one
+// after the other, such as the outputs of several test programs.  A document
+// ends where the next one starts with its version line, or with its plan if it
+// has no version line.  The documents after the first are named after
+// opt.Name, numbered from 2.
+//
+// A bail out ends only the document that it is in.
+func ReadAll(i io.Reader, opt ReadOpt) ([]Case, error) {
+	var cs []Case
+	p := NewParser(i)
+	rs := newReadState(opt, opt.Name, p)
+	rs.first = true
+	p.MaxLineLength = opt.MaxLineLength
+	err := p.Parse(func(e Event) error {
+		if rs.newDocument(e) {
+			c, err := rs.close()
+			cs = append(cs, c)
+			if err != nil {
+				rs = nil
+				return err
 			}
-			r.Results[ps.lt-1].Status = StatusFrom(v[7], PASSED)
-			r.Results[ps.lt-1].Raw = joinNonempty(r.Results[ps.lt-1].Raw, v[1])
-			r.Results[ps.lt-1].Header = strings.Trim(v[4], " ")
-			continue
+			rs = newReadState(opt, fmt.Sprintf("%s (%d)", opt.Name, len(cs)+1), p)
 		}
-
-		// NotOKTest is a failed test line.
-		// "not ok 42 some test # SKIP some comment"
-		// 1: test number, optional
-		// 3: text before #
-		var NotOKTest = regexp.MustCompile(
-			`^not ok( (\d+)?(\s+)?(([^#]*))?(#\s+(TODO|todo|SKIP|skip)?(.*))?)`)
-		if v := NotOKTest.FindStringSubmatch(t); v != nil {
-			glog.V(2).Infof("not ok: %v", spew.Sdump(v))
-			tiStr := v[2]
-			if tiStr != "" {
-				ps.lt = toInt(tiStr)
-			} else {
-				ps.lt++
-			}
-			copyResize(&r.Results, ps.lt)
-			if r.Last == nil || *r.Last < ps.lt {
-				l := ps.lt
-				r.Last = &l
-			}
-			r.Results[ps.lt-1].Status = StatusFrom(v[7], FAILED)
-			r.Results[ps.lt-1].Raw = joinNonempty(r.Results[ps.lt-1].Raw, v[1])
-			r.Results[ps.lt-1].Header = strings.Trim(v[4], " ")
-			continue
+		if rs.levels[0].r.BailOut != nil {
+			// The rest of the document is not read.
+			return nil
 		}
-
-		// An annotation is attached to the "current" test.
-		var TestAnnotation = regexp.MustCompile(`^#(\s+)?(.+)?`)
-		if v := TestAnnotation.FindStringSubmatch(t); v != nil {
-			glog.V(2).Infof("annotation: %v", spew.Sdump(v))
-			line := v[0]
-
-			// Extension parsing
-			if strings.HasPrefix(line, "# TAP2JUNIT:") {
-				line = strings.TrimPrefix(line, "# TAP2JUNIT:")
-				line = strings.TrimSpace(line)
-			}
-			var fixup int
-			glog.V(2).Infof("extension: %q", line)
-			if strings.HasPrefix(line, "Duration:") || opt.ReorderAll {
-				line = strings.TrimPrefix(line, "Duration:")
-				line = strings.TrimSpace(line)
-				if opt.ReorderDuration || opt.ReorderAll {
-					fixup = 1
-				}
-				glog.V(2).Infof("extension: %q, fixup: %v", line, fixup)
-
-				d, err := time.ParseDuration(line)
-				if err != nil {
-					glog.Warningf("could not parse duration: %v", line)
-				}
-				r.Results[ps.lt+fixup-1].Duration = d
-			}
-			glog.V(5).Infof(
-				"ps=%+v\n len(r.Results)=%v, r.Results=%+v\nfixup: %v\nv=%+v\nlt=%v\n\n",
-				ps, len(r.Results), r.Results, fixup, v, ps.lt,
-			)
-			r.Results[ps.lt+fixup-1].Raw = joinNonempty(r.Results[ps.lt+fixup-1].Raw, v[0])
-			continue
+		if err := rs.event(e); err != errBailedOut {
+			return err
 		}
-
-		var BailOut = regexp.MustCompile(`Bail out!\s*(.*)`)
-		if v := BailOut.FindStringSubmatch(t); v != nil {
-			glog.V(3).Infof("Found bail out! text: %q", v[1])
-			break
+		return nil
+	})
+	if err != nil {
+		if rs != nil {
+			// Keep what was read of the document with the problem.
+			rs.levels[0].flush()
+			cs = append(cs, *rs.levels[0].r)
 		}
-		glog.V(2).Infof("no match: %q", t)
-	}
-	if s.Err() != nil {
-		return r, s.Err()
+		return cs, err
 	}
-	return r, nil
+	c, err := rs.close()
+	return append(cs, c), err
 }
@@ -1,8 +1,12 @@
 package tap
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"runtime"
 	"runtime/debug"
 	"strings"
 	"testing"
@@ -60,9 +64,7 @@ func TestOutput(t *testing.T) {
 				Version: 12,
 				First:   ptr(1),
 				Last:    ptr(1),
-				Results: []Result{
-					{},
-				},
+				Missing: []int{1},
 			},
 		},
 		{
@@ -77,13 +79,17 @@ ok 2 Hello world # Some comment
 				Version: 12,
 				First:   ptr(1),
 				Last:    ptr(2),
+				Missing: []int{1},
 				Results: []Result{
 					{
 						Status: UNKNOWN,
 					},
 					{
-						Status: PASSED,
-						Header: "Hello world",
+						Status:      PASSED,
+						OK:          true,
+						Header:      "Hello world",
+						Number:      2,
+						Description: "Hello world",
 						Raw: ` 2 Hello world # Some comment
 # This is part of test 2`,
 					},
@@ -112,16 +118,22 @@ ok 2 Test 2
 				Last:    ptr(2),
 				Results: []Result{
 					{
-						Status: PASSED,
-						Header: "Hello world",
+						Status:      PASSED,
+						OK:          true,
+						Header:      "Hello world",
+						Number:      1,
+						Description: "Hello world",
 						Raw: `# TAP2JUNIT: Duration: 4.3ms
  1 Hello world # Some comment
 # This is part of test 1`,
 						Duration: 4300 * time.Microsecond,
 					},
 					{
-						Status: PASSED,
-						Header: "Test 2",
+						Status:      PASSED,
+						OK:          true,
+						Header:      "Test 2",
+						Number:      2,
+						Description: "Test 2",
 						Raw: ` 2 Test 2
 # This is part of test 2`,
 					},
@@ -146,14 +158,20 @@ ok 2 Hello world # TODO not done yet
 				Version: 12,
 				First:   ptr(1),
 				Last:    ptr(2),
+				Missing: []int{1},
 				Results: []Result{
 					{
 						Status: UNKNOWN,
 					},
 					{
-						Status: TODO,
-						Raw:    " 2 Hello world # TODO not done yet",
-						Header: "Hello world",
+						Status:          TODO,
+						OK:              true,
+						Raw:             " 2 Hello world # TODO not done yet",
+						Header:          "Hello world",
+						Number:          2,
+						Description:     "Hello world",
+						Directive:       "TODO",
+						DirectiveReason: "not done yet",
 					},
 				},
 				Raw: `
@@ -181,30 +199,46 @@ ok Unnumbered test
 				Version: 12,
 				First:   ptr(1),
 				Last:    ptr(9),
+				Missing: []int{1, 9},
 				Results: []Result{
 					{
 						Status: UNKNOWN,
 					},
 					{
-						Status: PASSED,
-						Raw:    " 2 Hello world # Some comment",
-						Header: "Hello world",
+						Status:      PASSED,
+						OK:          true,
+						Raw:         " 2 Hello world # Some comment",
+						Header:      "Hello world",
+						Number:      2,
+						Description: "Hello world",
 					},
 					{
-						Status: SKIPPED,
-						Raw:    " 3 Third test # SKIP not implemented yet",
-						Header: "Third test",
+						Status:          SKIPPED,
+						OK:              true,
+						Raw:             " 3 Third test # SKIP not implemented yet",
+						Header:          "Third test",
+						Number:          3,
+						Description:     "Third test",
+						Directive:       "SKIP",
+						DirectiveReason: "not implemented yet",
 					},
 					{
-						Status: TODO,
-						Raw:    " 4 Fourth test # TODO this is to be done",
-						Header: "Fourth test",
+						Status:          TODO,
+						OK:              true,
+						Raw:             " 4 Fourth test # TODO this is to be done",
+						Header:          "Fourth test",
+						Number:          4,
+						Description:     "Fourth test",
+						Directive:       "TODO",
+						DirectiveReason: "this is to be done",
 					},
 					{
 						Status: FAILED,
 						Raw: ` 5 Fifth test # Failed here
 # Part of fifth test`,
-						Header: `Fifth test`,
+						Header:      `Fifth test`,
+						Number:      5,
+						Description: "Fifth test",
 					},
 					{
 						// 5
@@ -212,23 +246,30 @@ ok Unnumbered test
 						Raw: " 6 Sixth test # SKIP Failed here\n" +
 							"# Some annotation\n" +
 							"# TAP2JUNIT: Duration: 10s",
-						Header:   "Sixth test",
-						Duration: duration("10s"),
+						Header:          "Sixth test",
+						Number:          6,
+						Description:     "Sixth test",
+						Directive:       "SKIP",
+						DirectiveReason: "Failed here",
+						Duration:        duration("10s"),
 					},
 					{
-						Status: TODO,
-						Raw:    " 7 Seventh test # TODO Failed here",
-						Header: "Seventh test",
+						Status:          TODO,
+						Raw:             " 7 Seventh test # TODO Failed here",
+						Header:          "Seventh test",
+						Number:          7,
+						Description:     "Seventh test",
+						Directive:       "TODO",
+						DirectiveReason: "Failed here",
 					},
 					{
 						// 7
-						Status: PASSED,
-						Raw:    " Unnumbered test",
-						Header: "Unnumbered test",
-					},
-					{
-						// 8, this test was not ran.
-						Status: UNKNOWN,
+						Status:      PASSED,
+						OK:          true,
+						Raw:         " Unnumbered test",
+						Header:      "Unnumbered test",
+						Number:      8,
+						Description: "Unnumbered test",
 					},
 				},
 			},
@@ -249,9 +290,12 @@ ok 1 Hello
 				Last:    ptr(1),
 				Results: []Result{
 					{
-						Status: PASSED,
-						Raw:    "# This should be reordered\n# And this too\n 1 Hello",
-						Header: "Hello",
+						Status:      PASSED,
+						OK:          true,
+						Raw:         "# This should be reordered\n# And this too\n 1 Hello",
+						Header:      "Hello",
+						Number:      1,
+						Description: "Hello",
 					},
 				},
 			},
@@ -268,16 +312,21 @@ ok 3 Belated result
 				Version: 12,
 				First:   ptr(1),
 				Last:    ptr(5),
+				Missing: []int{1, 3, 4, 5},
+				BailOut: &BailOut{
+					Token:  Token{Line: 4, Text: "Bail out! Some justification."},
+					Reason: "Some justification.",
+				},
 				Results: []Result{
 					{Status: UNKNOWN},
 					{
-						Status: PASSED,
-						Raw:    " 2 Hello world # Some comment",
-						Header: "Hello world",
+						Status:      PASSED,
+						OK:          true,
+						Raw:         " 2 Hello world # Some comment",
+						Header:      "Hello world",
+						Number:      2,
+						Description: "Hello world",
 					},
-					{Status: UNKNOWN},
-					{Status: UNKNOWN},
-					{Status: UNKNOWN},
 				},
 			},
 		},
@@ -297,9 +346,542 @@ not ok 1 Hello
 				Last:    ptr(1),
 				Results: []Result{
 					{
-						Status: FAILED,
-						Raw:    "# This should be reordered\n# And this too\n 1 Hello",
-						Header: "Hello",
+						Status:      FAILED,
+						Raw:         "# This should be reordered\n# And this too\n 1 Hello",
+						Header:      "Hello",
+						Number:      1,
+						Description: "Hello",
+					},
+				},
+			},
+		},
+		{
+			name: "YAML diagnostics",
+			input: `TAP version 13
+1..2
+not ok 1 Addition
+  ---
+  message: "1 + 1 should be 2"
+  severity: fail
+  found: 3
+  wanted: 2
+  at:
+    file: test/add.js
+    line: 12
+  ...
+ok 2 Subtraction
+`,
+			expected: Case{
+				Version: 13,
+				First:   ptr(1),
+				Last:    ptr(2),
+				Results: []Result{
+					{
+						Status:      FAILED,
+						Header:      "Addition",
+						Number:      1,
+						Description: "Addition",
+						Raw: ` 1 Addition
+  ---
+  message: "1 + 1 should be 2"
+  severity: fail
+  found: 3
+  wanted: 2
+  at:
+    file: test/add.js
+    line: 12
+  ...`,
+						YAML: `message: "1 + 1 should be 2"
+severity: fail
+found: 3
+wanted: 2
+at:
+  file: test/add.js
+  line: 12`,
+						Diagnostics: map[string]any{
+							"message":  "1 + 1 should be 2",
+							"severity": "fail",
+							"found":    "3",
+							"wanted":   "2",
+							"at": map[string]any{
+								"file": "test/add.js",
+								"line": "12",
+							},
+						},
+					},
+					{
+						Status:      PASSED,
+						OK:          true,
+						Header:      "Subtraction",
+						Number:      2,
+						Description: "Subtraction",
+						Raw:         " 2 Subtraction",
+					},
+				},
+			},
+		},
+		{
+			name: "Unterminated YAML diagnostics",
+			input: `1..1
+not ok 1 Addition
+  ---
+  message: failed
+`,
+			expected: Case{
+				Version: 12,
+				First:   ptr(1),
+				Last:    ptr(1),
+				Results: []Result{
+					{
+						Status:      FAILED,
+						Header:      "Addition",
+						Number:      1,
+						Description: "Addition",
+						Raw:         " 1 Addition\n  ---\n  message: failed",
+						YAML:        "message: failed",
+						Diagnostics: map[string]any{"message": "failed"},
+					},
+				},
+				Warnings: []*ParseError{
+					{
+						Line:   3,
+						Offset: 23,
+						Column: 3,
+						Text:   "---",
+						Reason: "unterminated YAML diagnostic block",
+					},
+				},
+			},
+		},
+		{
+			name: "Subtests",
+			input: `TAP version 14
+1..2
+# Subtest: first
+    1..2
+    ok 1 - inner one
+    # Subtest: nested
+        1..1
+        not ok 1 - deepest
+    not ok 2 - nested
+not ok 1 - first
+    # Subtest: second
+    ok 1 - only
+    1..1
+ok 2 - second
+`,
+			expected: Case{
+				Version: 14,
+				First:   ptr(1),
+				Last:    ptr(2),
+				Results: []Result{
+					{
+						Status:      FAILED,
+						Header:      "- first",
+						Number:      1,
+						Description: "first",
+						Raw:         " 1 - first",
+						Subtest: &Case{
+							Version: 14,
+							Name:    "first",
+							First:   ptr(1),
+							Last:    ptr(2),
+							Results: []Result{
+								{
+									Status:      PASSED,
+									OK:          true,
+									Header:      "- inner one",
+									Number:      1,
+									Description: "inner one",
+									Raw:         " 1 - inner one",
+								},
+								{
+									Status:      FAILED,
+									Header:      "- nested",
+									Number:      2,
+									Description: "nested",
+									Raw:         " 2 - nested",
+									Subtest: &Case{
+										Version: 14,
+										Name:    "nested",
+										First:   ptr(1),
+										Last:    ptr(1),
+										Results: []Result{
+											{
+												Status:      FAILED,
+												Header:      "- deepest",
+												Number:      1,
+												Description: "deepest",
+												Raw:         " 1 - deepest",
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+					{
+						Status:      PASSED,
+						OK:          true,
+						Header:      "- second",
+						Number:      2,
+						Description: "second",
+						Raw:         " 2 - second",
+						Subtest: &Case{
+							Version: 14,
+							Name:    "second",
+							First:   ptr(1),
+							Last:    ptr(1),
+							Results: []Result{
+								{
+									Status:      PASSED,
+									OK:          true,
+									Header:      "- only",
+									Number:      1,
+									Description: "only",
+									Raw:         " 1 - only",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Unnamed subtest without summary",
+			input: `1..1
+ok 1 - first
+    ok 1 - inner
+`,
+			expected: Case{
+				Version: 12,
+				First:   ptr(1),
+				Last:    ptr(2),
+				Results: []Result{
+					{
+						Status:      PASSED,
+						OK:          true,
+						Header:      "- first",
+						Number:      1,
+						Description: "first",
+						Raw:         " 1 - first",
+					},
+					{
+						Number: 2,
+						Subtest: &Case{
+							Version: 12,
+							Last:    ptr(1),
+							Results: []Result{
+								{
+									Status:      PASSED,
+									OK:          true,
+									Header:      "- inner",
+									Number:      1,
+									Description: "inner",
+									Raw:         " 1 - inner",
+								},
+							},
+						},
+					},
+				},
+				OutOfPlan: []int{2},
+				Warnings: []*ParseError{
+					{
+						Line:   3,
+						Offset: 18,
+						Column: 1,
+						Reason: `subtest "" has no summarizing test point`,
+					},
+				},
+			},
+		},
+		{
+			name: "Plan violations",
+			input: `1..3
+ok 1 one
+ok 1 one again
+ok 4 four
+`,
+			expected: Case{
+				Version: 12,
+				First:   ptr(1),
+				Last:    ptr(4),
+				Results: []Result{
+					{
+						Status:      PASSED,
+						OK:          true,
+						Header:      "one again",
+						Number:      1,
+						Description: "one again",
+						Raw:         " 1 one\n 1 one again",
+					},
+					{},
+					{},
+					{
+						Status:      PASSED,
+						OK:          true,
+						Header:      "four",
+						Number:      4,
+						Description: "four",
+						Raw:         " 4 four",
+					},
+				},
+				Missing:    []int{2, 3},
+				Duplicates: []int{1},
+				OutOfPlan:  []int{4},
+			},
+		},
+		{
+			name: "Bail out in a subtest",
+			input: `1..2
+# Subtest: first
+    1..2
+    ok 1 - inner
+    Bail out! No database.
+    ok 2 - belated
+ok 1 - first
+ok 2 - belated
+`,
+			expected: Case{
+				Version: 12,
+				First:   ptr(1),
+				Last:    ptr(2),
+				Results: []Result{
+					{
+						Header:      "first",
+						Number:      1,
+						Description: "first",
+						Subtest: &Case{
+							Version: 12,
+							Name:    "first",
+							First:   ptr(1),
+							Last:    ptr(2),
+							Results: []Result{
+								{
+									Status:      PASSED,
+									OK:          true,
+									Header:      "- inner",
+									Number:      1,
+									Description: "inner",
+									Raw:         " 1 - inner",
+								},
+							},
+							Missing: []int{2},
+							BailOut: &BailOut{
+								Token:  Token{Line: 5, Depth: 1, Text: "Bail out! No database."},
+								Reason: "No database.",
+							},
+						},
+					},
+				},
+				// The unsummarized subtest takes the place of test 1.
+				Missing: []int{2},
+				BailOut: &BailOut{
+					Token:  Token{Line: 5, Depth: 1, Text: "Bail out! No database."},
+					Reason: "No database.",
+				},
+			},
+		},
+		{
+			name: "Skip all",
+			input: `TAP version 13
+1..0 # SKIP no database
+`,
+			expected: Case{
+				Version:    13,
+				First:      ptr(1),
+				Last:       ptr(0),
+				Skipped:    true,
+				SkipReason: "no database",
+			},
+		},
+		{
+			name: "Empty plan",
+			input: `1..0
+`,
+			expected: Case{
+				Version: 12,
+				First:   ptr(1),
+				Last:    ptr(0),
+				Skipped: true,
+			},
+		},
+		{
+			name: "Plan with a comment",
+			input: `1..1 # just a comment
+ok 1 - only
+`,
+			expected: Case{
+				Version: 12,
+				First:   ptr(1),
+				Last:    ptr(1),
+				Results: []Result{
+					{
+						Status:      PASSED,
+						OK:          true,
+						Header:      "- only",
+						Number:      1,
+						Description: "only",
+						Raw:         " 1 - only",
+					},
+				},
+			},
+		},
+		{
+			name: "Preamble comments",
+			input: `# starting suite
+1..1
+# still starting
+ok 1 - first
+`,
+			expected: Case{
+				Version: 12,
+				First:   ptr(1),
+				Last:    ptr(1),
+				Output:  "# starting suite\n# still starting",
+				Results: []Result{
+					{
+						Status:      PASSED,
+						OK:          true,
+						Header:      "- first",
+						Number:      1,
+						Description: "first",
+						Raw:         " 1 - first",
+					},
+				},
+			},
+		},
+		{
+			name:       "Trailing reordered annotations",
+			reorder:    true,
+			reorderAll: true,
+			input: `# TAP2JUNIT: Duration: 1s
+ok 1 - first
+# TAP2JUNIT: Duration: 2s
+# left over
+`,
+			expected: Case{
+				Version: 12,
+				Last:    ptr(1),
+				Output:  "# TAP2JUNIT: Duration: 2s\n# left over",
+				Results: []Result{
+					{
+						Status:      PASSED,
+						OK:          true,
+						Header:      "- first",
+						Number:      1,
+						Description: "first",
+						Duration:    time.Second,
+						Raw:         "# TAP2JUNIT: Duration: 1s\n 1 - first",
+					},
+				},
+			},
+		},
+		{
+			name: "Test number out of range",
+			input: `ok 0 - zero
+ok 99999999999999999999 - huge
+`,
+			expected: Case{
+				Version: 12,
+				Last:    ptr(2),
+				Results: []Result{
+					{
+						Status:      PASSED,
+						OK:          true,
+						Header:      "- zero",
+						Number:      1,
+						Description: "zero",
+						Raw:         " 0 - zero",
+					},
+					{
+						Status:      PASSED,
+						OK:          true,
+						Header:      "- huge",
+						Number:      2,
+						Description: "huge",
+						Raw:         " 99999999999999999999 - huge",
+					},
+				},
+				Warnings: []*ParseError{
+					{
+						Line:   1,
+						Column: 4,
+						Text:   "ok 0 - zero",
+						Reason: "test number out of range: 0",
+					},
+					{
+						Line:   2,
+						Offset: 12,
+						Column: 4,
+						Text:   "ok 99999999999999999999 - huge",
+						Reason: "test number out of range: 9223372036854775807",
+					},
+				},
+			},
+		},
+		{
+			name: "Program output",
+			input: `connecting to the database
+1..2
+ok 1 - first
+first says hello
+
+first says goodbye
+not ok 2 - second
+second failed
+`,
+			expected: Case{
+				Version: 12,
+				First:   ptr(1),
+				Last:    ptr(2),
+				Output:  "connecting to the database",
+				Results: []Result{
+					{
+						Status:      PASSED,
+						OK:          true,
+						Header:      "- first",
+						Number:      1,
+						Description: "first",
+						Raw:         " 1 - first",
+						Output:      "first says hello\nfirst says goodbye",
+					},
+					{
+						Status:      FAILED,
+						Header:      "- second",
+						Number:      2,
+						Description: "second",
+						Raw:         " 2 - second",
+						Output:      "second failed",
+					},
+				},
+				Warnings: []*ParseError{
+					{Line: 1, Column: 1, Text: "connecting to the database", Reason: "not a TAP line"},
+					{Line: 4, Offset: 45, Column: 1, Text: "first says hello", Reason: "not a TAP line"},
+					{Line: 6, Offset: 63, Column: 1, Text: "first says goodbye", Reason: "not a TAP line"},
+					{Line: 8, Offset: 100, Column: 1, Text: "second failed", Reason: "not a TAP line"},
+				},
+			},
+		},
+		{
+			name: "Escaped hash",
+			input: `1..1
+ok 1 - issue \#42 # SKIP see \\\\server\\share
+`,
+			expected: Case{
+				Version: 12,
+				First:   ptr(1),
+				Last:    ptr(1),
+				Results: []Result{
+					{
+						Status:          SKIPPED,
+						OK:              true,
+						Header:          `- issue \#42`,
+						Number:          1,
+						Description:     "issue #42",
+						Directive:       "SKIP",
+						DirectiveReason: `see \\server\share`,
+						Raw:             ` 1 - issue \#42 # SKIP see \\\\server\\share`,
 					},
 				},
 			},
@@ -308,7 +890,10 @@ not ok 1 Hello
 	flag.Parse()
 
 	opts := cmp.Options{
-		cmpopts.IgnoreFields(Case{}, "Raw"),
+		cmpopts.IgnoreFields(Case{}, "Raw", "Plan", "Comments"),
+		// The positions are checked by TestPositions.
+		cmpopts.IgnoreFields(Result{}, "Span"),
+		cmpopts.IgnoreFields(Token{}, "EndLine", "Offset", "EndOffset"),
 	}
 
 	for _, test := range tests {
@@ -333,7 +918,772 @@ not ok 1 Hello
 			}
 			if !cmp.Equal(test.expected, actual, opts) {
 				t.Errorf("diff:\n%v\n, expected:\n%+v\nactual:\n%+v",
-					cmp.Diff(test.expected, actual), test.expected, actual)
+					cmp.Diff(test.expected, actual, opts), test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestReadAll(t *testing.T) {
+	// doc summarizes a Case.
+	type doc struct {
+		Name     string
+		Version  int
+		Tests    []string
+		Missing  []int
+		BailOut  string
+		Warnings int
+	}
+	tests := []struct {
+		name     string
+		input    string
+		expected []doc
+	}{
+		{
+			name: "One document",
+			input: `TAP version 13
+1..1
+ok 1 - only
+`,
+			expected: []doc{
+				{Name: "all", Version: 13, Tests: []string{"only"}},
+			},
+		},
+		{
+			name: "Versions",
+			input: `TAP version 13
+1..1
+ok 1 - first
+TAP version 14
+# second program
+1..2
+ok 1 - second
+ok 2 - third
+`,
+			expected: []doc{
+				{Name: "all", Version: 13, Tests: []string{"first"}},
+				{Name: "all (2)", Version: 14, Tests: []string{"second", "third"}},
+			},
+		},
+		{
+			name: "Version in a comment",
+			input: `1..2
+ok 1 - a
+# upgrading to TAP version 14 soon
+ok 2 - b
+`,
+			expected: []doc{
+				{Name: "all", Version: 12, Tests: []string{"a", "b"}},
+			},
+		},
+		{
+			name: "Version in a description",
+			input: `1..2
+ok 1 - first
+ok 2 - parses TAP version 13 headers
+`,
+			expected: []doc{
+				{Name: "all", Version: 12, Tests: []string{"first", "parses TAP version 13 headers"}},
+			},
+		},
+		{
+			name: "Leading plans",
+			input: `1..2
+ok 1 - first
+ok 2 - second
+1..1
+ok 1 - third
+`,
+			expected: []doc{
+				{Name: "all", Version: 12, Tests: []string{"first", "second"}},
+				{Name: "all (2)", Version: 12, Tests: []string{"third"}},
+			},
+		},
+		{
+			name: "Trailing plans",
+			input: `ok 1 - first
+1..1
+# after the first program
+ok 1 - second
+    ok 1 - inner
+ok 2 - third
+1..2
+`,
+			expected: []doc{
+				{Name: "all", Version: 12, Tests: []string{"first"}},
+				{Name: "all (2)", Version: 12, Tests: []string{"second", "third"}},
+			},
+		},
+		{
+			name: "Bail out ends a document",
+			input: `1..2
+ok 1 - first
+Bail out! Crashed.
+ok 2 - ignored
+1..1
+ok 1 - second
+`,
+			expected: []doc{
+				{Name: "all", Version: 12, Tests: []string{"first"}, Missing: []int{2}, BailOut: "Crashed."},
+				{Name: "all (2)", Version: 12, Tests: []string{"second"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			cs, err := ReadAll(strings.NewReader(test.input), ReadOpt{Name: "all"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var actual []doc
+			for _, c := range cs {
+				d := doc{Name: c.Name, Version: c.Version, Missing: c.Missing, Warnings: len(c.Warnings)}
+				for _, r := range c.Results {
+					d.Tests = append(d.Tests, r.Description)
+				}
+				if c.BailOut != nil {
+					d.BailOut = c.BailOut.Reason
+				}
+				actual = append(actual, d)
+			}
+			if !cmp.Equal(test.expected, actual) {
+				t.Errorf("diff:\n%v", cmp.Diff(test.expected, actual))
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []*ParseError
+	}{
+		{
+			name: "Unknown line",
+			input: `1..1
+some program output
+ok 1 - fine
+`,
+			expected: []*ParseError{
+				{Line: 2, Offset: 5, Column: 1, Text: "some program output", Reason: "not a TAP line"},
+			},
+		},
+		{
+			name: "Invalid duration",
+			input: `1..1
+ok 1 - fine
+# TAP2JUNIT: Duration: forever
+`,
+			expected: []*ParseError{
+				{
+					Line:   3,
+					Offset: 17,
+					Column: 24,
+					Text:   "# TAP2JUNIT: Duration: forever",
+					Reason: `invalid duration: "forever"`,
+				},
+			},
+		},
+		{
+			name: "Invalid YAML",
+			input: `1..1
+not ok 1 - broken
+  ---
+  message: one
+      nested: too deep
+  ...
+`,
+			expected: []*ParseError{
+				{
+					Line:   5,
+					Offset: 44,
+					Column: 3,
+					Text:   "      nested: too deep",
+					Reason: "yaml: line 2: unexpected indentation",
+				},
+			},
+		},
+		{
+			name: "Problem in a subtest",
+			input: `1..1
+# Subtest: sub
+    1..1
+    garbage
+    ok 1 - inner
+ok 1 - sub
+`,
+			expected: []*ParseError{
+				{Line: 4, Offset: 29, Column: 1, Text: "garbage", Reason: "not a TAP line"},
+			},
+		},
+		{
+			name: "Unknown directive",
+			input: `1..1
+ok 1 - fine
+# TAP2JUNIT: Colour: blue
+`,
+			expected: []*ParseError{
+				{Line: 3, Offset: 17, Column: 14, Text: "# TAP2JUNIT: Colour: blue", Reason: `unknown directive: "Colour"`},
+			},
+		},
+		{
+			name: "Invalid property",
+			input: `1..1
+ok 1 - fine
+# TAP2JUNIT: Property: owner
+`,
+			expected: []*ParseError{
+				{
+					Line:   3,
+					Offset: 17,
+					Column: 24,
+					Text:   "# TAP2JUNIT: Property: owner",
+					Reason: `invalid property, want name=value: "owner"`,
+				},
+			},
+		},
+		{
+			name: "Invalid duration_ms",
+			input: `1..1
+ok 1 - fine
+  ---
+  duration_ms: soon
+  ...
+`,
+			expected: []*ParseError{
+				{
+					Line:   4,
+					Offset: 23,
+					Column: 3,
+					Text:   "  duration_ms: soon",
+					Reason: `invalid duration in milliseconds: "soon"`,
+				},
+			},
+		},
+		{
+			name: "Invalid time",
+			input: `1..1
+ok 1 - fine # time=soon
+`,
+			expected: []*ParseError{
+				{Line: 2, Offset: 5, Column: 20, Text: "ok 1 - fine # time=soon", Reason: `invalid duration: "soon"`},
+			},
+		},
+		{
+			name: "Unknown pragma",
+			input: `pragma +bogus
+1..1
+ok 1 - fine
+`,
+			expected: []*ParseError{
+				{Line: 1, Column: 8, Text: "pragma +bogus", Reason: `unknown pragma: "bogus"`},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			actual, err := Read(strings.NewReader(test.input), ReadOpt{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cmp.Equal(test.expected, actual.Warnings) {
+				t.Errorf("warnings diff:\n%v", cmp.Diff(test.expected, actual.Warnings))
+			}
+
+			_, err = Read(strings.NewReader(test.input), ReadOpt{Strict: true})
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("expected a *ParseError in strict mode, got: %v", err)
+			}
+			if !cmp.Equal(test.expected[0], pe) {
+				t.Errorf("error diff:\n%v", cmp.Diff(test.expected[0], pe))
+			}
+		})
+	}
+}
+
+func TestPragmas(t *testing.T) {
+	input := `TAP version 14
+non-TAP output is fine
+pragma +strict
+1..2
+ok 1 - first
+pragma -strict
+more output
+ok 2 - second
+`
+	c, err := Read(strings.NewReader(input), ReadOpt{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Pragma{
+		{Token: Token{Line: 3, EndLine: 3, Offset: 38, EndOffset: 53, Text: "pragma +strict"}, Key: "strict", On: true},
+		{Token: Token{Line: 6, EndLine: 6, Offset: 71, EndOffset: 86, Text: "pragma -strict"}, Key: "strict"},
+	}
+	if !cmp.Equal(expected, c.Pragmas) {
+		t.Errorf("pragmas diff:\n%v", cmp.Diff(expected, c.Pragmas))
+	}
+	if len(c.Warnings) != 2 {
+		t.Errorf("expected warnings for lines 2 and 7, got: %v", c.Warnings)
+	}
+
+	// An unknown line in the strict region is an error.
+	input = strings.Replace(input, "ok 1 - first", "garbage", 1)
+	_, err = Read(strings.NewReader(input), ReadOpt{})
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Line != 5 || pe.Offset != 58 {
+		t.Errorf("expected a *ParseError for line 5 at byte 58, got: %v", err)
+	}
+}
+
+func TestPragmaReorder(t *testing.T) {
+	input := `1..3
+ok 1 - first
+# TAP2JUNIT: Duration: 1s
+pragma +tap2junit-reorder-duration
+# TAP2JUNIT: Duration: 2s
+ok 2 - second
+pragma -tap2junit-reorder-duration
+ok 3 - third
+# TAP2JUNIT: Duration: 3s
+`
+	c, err := Read(strings.NewReader(input), ReadOpt{Strict: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var actual []time.Duration
+	for _, r := range c.Results {
+		actual = append(actual, r.Duration)
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if !cmp.Equal(expected, actual) {
+		t.Errorf("durations diff:\n%v", cmp.Diff(expected, actual))
+	}
+}
+
+func TestInferDurations(t *testing.T) {
+	r, w := io.Pipe()
+	go func() {
+		defer w.Close()
+		// The lines arrive as the tests complete.
+		for _, l := range []string{
+			"1..3",
+			"ok 1 - first",
+			"ok 2 - second",
+			"# TAP2JUNIT: Duration: 1h",
+			"ok 3 - third",
+		} {
+			time.Sleep(20 * time.Millisecond)
+			io.WriteString(w, l+"\n")
+		}
+	}()
+	start := time.Now()
+	c, err := Read(r, ReadOpt{InferDurations: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Start.Before(start) || c.Start.After(start.Add(20*time.Millisecond)) {
+		t.Errorf("got start %v, expected about %v", c.Start, start)
+	}
+	var actual []time.Duration
+	for _, r := range c.Results {
+		actual = append(actual, r.Duration)
+	}
+	if len(actual) != 3 || actual[0] < 40*time.Millisecond || actual[1] != time.Hour || actual[2] < 40*time.Millisecond {
+		t.Errorf("got durations %v, expected at least 40ms, 1h and at least 40ms", actual)
+	}
+	if c.Duration < 100*time.Millisecond {
+		t.Errorf("got suite duration %v, expected at least 100ms", c.Duration)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	blob := strings.Repeat("x", 1<<20)
+	input := "1..2\nok 1 - first\n# " + blob + "\nnot ok 2 - " + blob + "\n#ééééééé\n"
+
+	c, err := Read(strings.NewReader(input), ReadOpt{Strict: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.Results) != 2 || c.Results[1].Description != blob {
+		t.Errorf("long lines were not read whole: %+v", c.Results)
+	}
+
+	c, err = Read(strings.NewReader(input), ReadOpt{MaxLineLength: 12})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Result{
+		{
+			Status:      PASSED,
+			OK:          true,
+			Header:      "- first",
+			Number:      1,
+			Description: "first",
+			Raw:         " 1 - first\n# xxxxxxxxxx",
+			Truncated:   true,
+		},
+		{
+			Status:      FAILED,
+			Header:      "- x",
+			Number:      2,
+			Description: "x",
+			// Not cut in the middle of a character.
+			Raw:       " 2 - x\n#ééééé",
+			Truncated: true,
+		},
+	}
+	opt := cmpopts.IgnoreFields(Result{}, "Span")
+	if !cmp.Equal(expected, c.Results, opt) {
+		t.Errorf("diff:\n%v", cmp.Diff(expected, c.Results, opt))
+	}
+	var lines []int
+	var offsets []int64
+	for _, w := range c.Warnings {
+		lines = append(lines, w.Line)
+		offsets = append(offsets, w.Offset)
+	}
+	if !cmp.Equal([]int{3, 4, 5}, lines) {
+		t.Errorf("got warnings %v, expected truncated lines 3, 4 and 5", c.Warnings)
+	}
+	line4 := int64(len("1..2\nok 1 - first\n# " + blob + "\n"))
+	expectedOffsets := []int64{18, line4, line4 + int64(len("not ok 2 - "+blob+"\n"))}
+	if !cmp.Equal(expectedOffsets, offsets) {
+		t.Errorf("warning offsets diff:\n%v", cmp.Diff(expectedOffsets, offsets))
+	}
+}
+
+func FuzzRead(f *testing.F) {
+	for _, seed := range []string{
+		"# comment before any test\n",
+		"1..1\n# comment before the first test\nok 1\n",
+		"ok 1\n# TAP2JUNIT: Duration: 1s\n",
+		"ok 0\n",
+		"1..99999999999999999999\n",
+		"ok 1\n  ---\n  a:\n  - b: c\n    d: |\n      e\n",
+		"# Subtest: a\n    # Subtest: b\n        ok 1\n    Bail out!\n",
+		"1..0 # SKIP no reason\n",
+		"pragma +strict\nok 1\npragma -strict\n",
+		"\ufeff1..1\r\nok 1 - \xff\r\n",
+		"\xff\xfe1\x00.\x00.\x001\x00\n\x00\x00\xd8",
+		"ok 65536\nok \n",
+	} {
+		f.Add(seed, false, false)
+		f.Add(seed, true, true)
+	}
+	f.Fuzz(func(t *testing.T, input string, reorder, reorderAll bool) {
+		opt := ReadOpt{
+			ReorderDuration: reorder,
+			ReorderAll:      reorderAll,
+		}
+		if _, err := Read(strings.NewReader(input), opt); err != nil {
+			t.Skip()
+		}
+	})
+}
+
+// largeTAP returns a TAP stream of about size bytes, with tests that fail
+// with YAML diagnostics, annotations and program output.
+func largeTAP(size int) string {
+	var b strings.Builder
+	b.WriteString("TAP version 13\n")
+	for n := 1; b.Len() < size; n++ {
+		fmt.Fprintf(&b, "# TAP2JUNIT: Duration: %dms\n", n%1000)
+		if n%10 == 0 {
+			fmt.Fprintf(&b, "not ok %d - test number %d\n", n, n)
+			b.WriteString("  ---\n  message: the test failed\n  severity: fail\n  found: 1\n  wanted: 2\n  ...\n")
+		} else {
+			fmt.Fprintf(&b, "ok %d - test number %d\n", n, n)
+		}
+		fmt.Fprintf(&b, "output of test %d, which is not TAP\n", n)
+		b.WriteString("more output, which is not TAP either, and which makes the test longer\n")
+	}
+	return b.String()
+}
+
+var benchmarkSizes = []struct {
+	name string
+	size int
+}{
+	{"1MiB", 1 << 20},
+	{"16MiB", 16 << 20},
+	{"256MiB", 256 << 20},
+}
+
+func BenchmarkRead(b *testing.B) {
+	for _, s := range benchmarkSizes {
+		b.Run(s.name, func(b *testing.B) {
+			input := largeTAP(s.size)
+			for _, opt := range []struct {
+				name string
+				opt  ReadOpt
+			}{
+				{"KeepRaw", ReadOpt{}},
+				{"DiscardRaw", ReadOpt{DiscardRaw: true}},
+			} {
+				b.Run(opt.name, func(b *testing.B) {
+					b.SetBytes(int64(len(input)))
+					b.ReportAllocs()
+					for i := 0; i < b.N; i++ {
+						if _, err := Read(strings.NewReader(input), opt.opt); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkParser(b *testing.B) {
+	for _, s := range benchmarkSizes {
+		b.Run(s.name, func(b *testing.B) {
+			input := largeTAP(s.size)
+			b.SetBytes(int64(len(input)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := NewParser(strings.NewReader(input)).Parse(func(Event) error {
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := `1..2
+ok 1 - first
+  ---
+  message: fine
+  ...
+# TAP2JUNIT: Duration: 1s
+not ok 2 - second
+program output
+# a comment
+Bail out! Stop.
+`
+	c, err := Read(strings.NewReader(input), ReadOpt{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var actual []Span
+	for _, r := range c.Results {
+		actual = append(actual, r.Span)
+	}
+	expected := []Span{
+		{Line: 2, EndLine: 6, Offset: 5, EndOffset: 72},
+		{Line: 7, EndLine: 9, Offset: 72, EndOffset: 117},
+	}
+	if !cmp.Equal(expected, actual) {
+		t.Errorf("results diff:\n%v", cmp.Diff(expected, actual))
+	}
+	if c.Plan == nil || c.Plan.Span() != (Span{Line: 1, EndLine: 1, EndOffset: 5}) {
+		t.Errorf("got plan %+v, expected line 1", c.Plan)
+	}
+	if c.BailOut == nil || c.BailOut.Span() != (Span{Line: 10, EndLine: 10, Offset: 117, EndOffset: 133}) {
+		t.Errorf("got bail out %+v, expected line 10", c.BailOut)
+	}
+	if len(c.Comments) != 2 || c.Comments[1].Line != 9 || c.Comments[1].Offset != 105 {
+		t.Errorf("got comments %+v, expected the last one at line 9", c.Comments)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	for _, s := range []Status{UNKNOWN, PASSED, FAILED, SKIPPED, TODO} {
+		b, err := s.MarshalText()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(b) != s.String() {
+			t.Errorf("got text %q, expected %q", b, s.String())
+		}
+		var actual Status
+		if err := actual.UnmarshalText(b); err != nil || actual != s {
+			t.Errorf("got %v (error %v), expected %v", actual, err, s)
+		}
+	}
+	if s := fmt.Sprint(SKIPPED); s != "skipped" {
+		t.Errorf("got %q, expected skipped", s)
+	}
+	if s := Status(42).String(); s != "Status(42)" {
+		t.Errorf("got %q, expected Status(42)", s)
+	}
+	if _, err := Status(42).MarshalText(); err == nil {
+		t.Errorf("expected an error for an unknown status")
+	}
+
+	var actual []Status
+	if err := json.Unmarshal([]byte(`["failed", 3, "todo"]`), &actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []Status{FAILED, SKIPPED, TODO}; !cmp.Equal(expected, actual) {
+		t.Errorf("diff:\n%v", cmp.Diff(expected, actual))
+	}
+	for _, input := range []string{`"bogus"`, `42`, `true`} {
+		var s Status
+		if err := json.Unmarshal([]byte(input), &s); err == nil {
+			t.Errorf("expected an error for %s, got %v", input, s)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	input := `TAP version 14
+pragma +strict
+1..4
+ok 1 - first
+# TAP2JUNIT: Start: 2024-06-01T12:00:00Z
+# TAP2JUNIT: End: 2024-06-01T12:00:01.5+02:00
+# TAP2JUNIT: Property: owner=alice
+not ok 2 - second
+  ---
+  message: broken
+  data:
+    - a: b
+    - c
+  ...
+pragma -strict
+some output
+# Subtest: sub
+    ok 1 - inner # SKIP no database
+    1..1
+ok 3 - sub
+Bail out! Done.
+`
+	c, err := Read(strings.NewReader(input), ReadOpt{Name: "json"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.Results) != 3 || len(c.Missing) != 1 || c.Results[2].Subtest == nil || c.Results[1].Diagnostics["data"] == nil ||
+		len(c.Warnings) == 0 || c.BailOut == nil || c.Results[0].End.IsZero() {
+		t.Fatalf("the input was not read as expected: %+v", c)
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(b), `"Status":"failed"`) {
+		t.Errorf("the status is not written by name:\n%s", b)
+	}
+	if !strings.Contains(string(b), `"Dialect":"generic"`) {
+		t.Errorf("the dialect is not written by name:\n%s", b)
+	}
+	var actual Case
+	if err := json.Unmarshal(b, &actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cmp.Equal(c, actual) {
+		t.Errorf("diff:\n%v", cmp.Diff(c, actual))
+	}
+}
+
+func TestLargePlan(t *testing.T) {
+	// An 11 byte plan must not make room for all of its tests up front.
+	input := fmt.Sprintf("1..%d\n", maxTestJump)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	c, err := Read(strings.NewReader(input), ReadOpt{})
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.Results) != 0 || len(c.Missing) != maxTestJump {
+		t.Errorf("got %d results and %d missing, expected 0 and %d", len(c.Results), len(c.Missing), maxTestJump)
+	}
+	// Missing takes 8 bytes a test.
+	if n := after.TotalAlloc - before.TotalAlloc; n > 12*maxTestJump {
+		t.Errorf("reading the plan allocated %d bytes", n)
+	}
+}
+
+func TestManyTests(t *testing.T) {
+	// More tests than there once was room for.
+	const n = 70000
+	var b strings.Builder
+	fmt.Fprintf(&b, "1..%d\n", n)
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "ok %d\n", i)
+	}
+	c, err := Read(strings.NewReader(b.String()), ReadOpt{Strict: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.Results) != n || len(c.Missing) != 0 {
+		t.Errorf("got %d results and %d missing, expected %d and none", len(c.Results), len(c.Missing), n)
+	}
+
+	// Unnumbered tests count up as well.
+	c, err = Read(strings.NewReader(strings.Repeat("ok - again\n", 100000)), ReadOpt{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.Results) != 100000 || len(c.Warnings) != 0 {
+		t.Errorf("got %d results and %d warnings, expected 100000 and none", len(c.Results), len(c.Warnings))
+	}
+
+	c, err = Read(strings.NewReader(fmt.Sprintf("ok 1\nok %d\n1..2\n", maxTestJump+2)), ReadOpt{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.Results) != 2 || len(c.Warnings) != 1 {
+		t.Errorf("got %d results and warnings %v, expected 2 results and a warning for the jump", len(c.Results), c.Warnings)
+	}
+}
+
+func TestNumberGaps(t *testing.T) {
+	tests := []struct {
+		name               string
+		input              string
+		missing, outOfPlan []int
+		duplicates         []int
+	}{
+		{
+			name:      "Past the plan",
+			input:     "1..2\nok 1 a\nok 2 b\nok 5 c\n",
+			missing:   []int{3, 4},
+			outOfPlan: []int{5},
+		},
+		{
+			name:    "Without a plan",
+			input:   "ok 1 a\nok 3 c\n",
+			missing: []int{2},
+		},
+		{
+			name: "Subtest without a summary",
+			input: `1..2
+# Subtest: a
+    ok 1 - x
+# Subtest: b
+    ok 1 - y
+ok 1 - b
+`,
+			missing:    []int{2},
+			duplicates: []int{1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := Read(strings.NewReader(test.input), ReadOpt{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cmp.Equal(test.missing, c.Missing) {
+				t.Errorf("missing diff:\n%v", cmp.Diff(test.missing, c.Missing))
+			}
+			if !cmp.Equal(test.outOfPlan, c.OutOfPlan) {
+				t.Errorf("out of plan diff:\n%v", cmp.Diff(test.outOfPlan, c.OutOfPlan))
+			}
+			if !cmp.Equal(test.duplicates, c.Duplicates) {
+				t.Errorf("duplicates diff:\n%v", cmp.Diff(test.duplicates, c.Duplicates))
 			}
 		})
 	}
@@ -2,57 +2,441 @@
 package tojunit
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/filmil/tap2junit/pkg/junit"
 	"github.com/filmil/tap2junit/pkg/tap"
 )
 
+// Outcome is how a TAP result is reported in jUnit.  It is written as its name
+// in text and in JSON, such as "skipped".
+type Outcome int
+
+const (
+	// Skipped reports a result as a skipped test.
+	Skipped Outcome = iota
+	// Passed reports a result as a passed test.
+	Passed
+	// Failed reports a result as a test failure.
+	Failed
+)
+
+var outcomeNames = map[Outcome]string{
+	Skipped: "skipped",
+	Passed:  "passed",
+	Failed:  "failed",
+}
+
+// String implements flag.Value and fmt.Stringer.
+func (o Outcome) String() string {
+	if n, ok := outcomeNames[o]; ok {
+		return n
+	}
+	return fmt.Sprintf("Outcome(%d)", int(o))
+}
+
+// Set implements flag.Value.
+func (o *Outcome) Set(s string) error {
+	return o.UnmarshalText([]byte(s))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (o Outcome) MarshalText() ([]byte, error) {
+	n, ok := outcomeNames[o]
+	if !ok {
+		return nil, fmt.Errorf("unknown outcome: %d", int(o))
+	}
+	return []byte(n), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (o *Outcome) UnmarshalText(b []byte) error {
+	for k, v := range outcomeNames {
+		if v == string(b) {
+			*o = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown outcome: %q, want one of: skipped, passed, failed", b)
+}
+
+// ConvertOpt is the set of options passed to configure the conversion.  The
+// zero value reports all SKIP and TODO results as skipped tests.
+type ConvertOpt struct {
+	// Skip is how the SKIP results are reported.
+	Skip Outcome
+	// TodoFailed is how the "not ok" TODO results are reported.
+	TodoFailed Outcome
+	// TodoPassed is how the "ok" TODO results, the bonus tests, are reported.
+	TodoPassed Outcome
+	// Positions adds the input lines and byte offsets of each test, of the
+	// plan and of the bail out as "tap.*" properties.
+	Positions bool
+}
+
 func strHash(s string) string {
 	h := sha256.New()
 	h.Write([]byte(s))
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-// FromTAP converts a TAP test case into a jUnit testsuite.
+// withoutKey returns the YAML mapping y with the top-level entry key, and any
+// lines nested under it, removed.
+func withoutKey(y, key string) string {
+	var (
+		ls   []string
+		skip bool
+	)
+	for _, l := range strings.Split(y, "\n") {
+		if l != "" && !strings.HasPrefix(l, " ") {
+			skip = strings.HasPrefix(l, key+":")
+		}
+		if !skip {
+			ls = append(ls, l)
+		}
+	}
+	return strings.Join(ls, "\n")
+}
+
+// addProperty appends the property name=value to ps.
+func addProperty(ps **junit.Properties, name, value string) {
+	if *ps == nil {
+		*ps = &junit.Properties{}
+	}
+	(*ps).Properties = append((*ps).Properties, junit.Property{Name: name, Value: value})
+}
+
+// addSpan appends the properties giving the position s in the input to ps,
+// with names starting with prefix.
+func addSpan(ps **junit.Properties, prefix string, s tap.Span) {
+	if s.Line == 0 {
+		return
+	}
+	addProperty(ps, prefix+"line", fmt.Sprint(s.Line))
+	addProperty(ps, prefix+"end_line", fmt.Sprint(s.EndLine))
+	addProperty(ps, prefix+"offset", fmt.Sprint(s.Offset))
+	addProperty(ps, prefix+"end_offset", fmt.Sprint(s.EndOffset))
+}
+
+// classPath appends name to the dot-separated class path p.
+func classPath(p, name string) string {
+	if p == "" {
+		return name
+	}
+	return p + "." + name
+}
+
+// outcome returns how the result r is reported.
+func outcome(r tap.Result, opt ConvertOpt) Outcome {
+	switch r.Status {
+	case tap.FAILED:
+		return Failed
+	case tap.SKIPPED:
+		return opt.Skip
+	case tap.TODO:
+		if r.OK {
+			return opt.TodoPassed
+		}
+		return opt.TodoFailed
+	}
+	return Passed
+}
+
+// testcase converts a single TAP result into a jUnit test case.
+func testcase(r tap.Result, classname string, opt ConvertOpt) junit.Case {
+	var c junit.Case
+	if r.Classname != "" {
+		classname = r.Classname
+	}
+	c.ID = strHash(classPath(classname, r.Description))
+	c.Name = r.Description
+	c.Classname = classname
+	c.File, c.Line = r.File, r.Line
+	c.Timestamp = junit.Timestamp{Time: r.Start}
+	for _, p := range r.Properties {
+		addProperty(&c.Properties, p.Name, p.Value)
+	}
+	if opt.Positions {
+		addSpan(&c.Properties, "tap.", r.Span)
+	}
+	switch outcome(r, opt) {
+	case Skipped:
+		c.Skipped = &junit.Skipped{Message: r.DirectiveReason}
+	case Failed:
+		var f junit.Failure
+		f.Type = "TestFailed"
+		f.Text = r.Raw
+		f.Message = r.Description
+		if m, ok := r.Diagnostics["message"].(string); ok && m != "" {
+			// The YAML diagnostics carry a failure message, make it the
+			// headline and the rest of the diagnostics the details.
+			f.Message = strings.TrimSpace(m)
+			f.Text = withoutKey(r.YAML, "message")
+		}
+		// Test message - full first line
+		c.Failures = append(c.Failures, f)
+	}
+	c.Time = junit.DurationSec{Duration: r.Duration}
+	out := r.Output
+	for _, a := range r.Attachments {
+		// As understood by the Jenkins JUnit Attachments plugin.
+		if out != "" {
+			out += "\n"
+		}
+		out += fmt.Sprintf("[[ATTACHMENT|%s]]", a)
+	}
+	if out != "" {
+		c.SystemOut = &junit.Output{Text: out}
+	}
+	return c
+}
+
+// planError is a synthetic test case reporting that the test number n did not
+// go according to the plan.
+func planError(classname string, n int, what, typ, msg string) junit.Case {
+	name := fmt.Sprintf("test %d (%s)", n, what)
+	return junit.Case{
+		ID:        strHash(classPath(classname, name)),
+		Name:      name,
+		Classname: classname,
+		Errors: []junit.Error{
+			{
+				Type:    typ,
+				Message: fmt.Sprintf(msg, n),
+			},
+		},
+	}
+}
+
+// missingTest is a synthetic test case reporting that the test number n of c
+// reported no result.
+func missingTest(c tap.Case, classname string, n int) junit.Case {
+	switch {
+	case c.BailOut != nil:
+		return planError(classname, n, "not run", "NotRun",
+			"test %d did not run, because the test run bailed out")
+	case planned(c, n):
+		return planError(classname, n, "missing", "MissingTest",
+			"test %d was planned, but reported no result")
+	}
+	return planError(classname, n, "not reported", "MissingTest",
+		"test %d reported no result, but later tests did")
+}
+
+// planned returns true if the test number n is in the plan of c.
+func planned(c tap.Case, n int) bool {
+	if c.Plan != nil {
+		return n >= c.Plan.First && n <= c.Plan.Last
+	}
+	return c.First != nil && c.Last != nil && n >= *c.First && n <= *c.Last
+}
+
+// bailOut is a synthetic test case reporting that the test run was aborted.
+func bailOut(b tap.BailOut, opt ConvertOpt) junit.Case {
+	const name = "Bail out!"
+	c := junit.Case{
+		ID:   strHash(name),
+		Name: name,
+		Errors: []junit.Error{
+			{
+				Type:    "BailOut",
+				Message: b.Reason,
+				Text:    fmt.Sprintf("line %d: Bail out! %s", b.Line, b.Reason),
+			},
+		},
+	}
+	if opt.Positions {
+		addSpan(&c.Properties, "tap.", b.Span())
+	}
+	return c
+}
+
+// incomplete is a synthetic test case reporting that the report lacks the
+// tests after the point where reading or converting stopped.
+func incomplete(c tap.Case) junit.Case {
+	const name = "Incomplete"
+	return junit.Case{
+		ID:   strHash(classPath(c.Name, name)),
+		Name: name,
+		Errors: []junit.Error{
+			{
+				Type:    "Incomplete",
+				Message: "the test report is incomplete, it was cut short before the end of the test run",
+			},
+		},
+	}
+}
+
+// testcases converts the results of c into jUnit test cases.  Subtests are
+// flattened, with the path of subtest names as the test case classname.  It
+// stops early if ctx is done.
+func testcases(ctx context.Context, c tap.Case, classname string, opt ConvertOpt) []junit.Case {
+	var cs []junit.Case
+	missing := map[int]bool{}
+	for _, n := range c.Missing {
+		missing[n] = true
+	}
+	for i, r := range c.Results {
+		if ctx.Err() != nil {
+			return cs
+		}
+		if missing[i+1] {
+			cs = append(cs, missingTest(c, classname, i+1))
+			continue
+		}
+		if r.Status == tap.UNKNOWN && (r.Subtest == nil || len(r.Subtest.Results) == 0) {
+			// Not a test that passed, whatever the reason it has no result.
+			cs = append(cs, planError(classname, i+1, "no result", "MissingTest",
+				"test %d reported no result"))
+			continue
+		}
+		if r.Subtest == nil || len(r.Subtest.Results) == 0 {
+			cs = append(cs, testcase(r, classname, opt))
+			continue
+		}
+		sub := testcases(ctx, *r.Subtest, classPath(classname, r.Subtest.Name), opt)
+		if r.Status == tap.FAILED && !anyFailed(sub) {
+			// The subtest failed as a whole, for example because it did not
+			// run all of its planned tests.  Don't lose that.
+			cs = append(cs, testcase(r, classname, opt))
+		}
+		cs = append(cs, sub...)
+	}
+	for _, n := range c.Missing {
+		// The planned tests past the last one reported have no result.
+		if n > len(c.Results) {
+			cs = append(cs, missingTest(c, classname, n))
+		}
+	}
+	for _, n := range c.Duplicates {
+		cs = append(cs, planError(classname, n, "duplicate", "DuplicateTest",
+			"test %d reported a result more than once"))
+	}
+	for _, n := range c.OutOfPlan {
+		cs = append(cs, planError(classname, n, "out of plan", "OutOfPlanTest",
+			"test %d reported a result, but is outside of the plan"))
+	}
+	if c.Skipped && len(c.Results) == 0 {
+		// "1..0 # SKIP reason", the entire test was skipped.
+		cs = append(cs, testcase(tap.Result{
+			Status:          tap.SKIPPED,
+			Description:     c.Name,
+			DirectiveReason: c.SkipReason,
+		}, classname, opt))
+	}
+	return cs
+}
+
+func anyFailed(cs []junit.Case) bool {
+	for _, c := range cs {
+		if len(c.Failures) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// FromTAP converts a TAP test case into a jUnit testsuite, with the zero
+// ConvertOpt.  An incomplete test case, see tap.ReadContext, gets an
+// "Incomplete" error test case.
 func FromTAP(c tap.Case) (junit.Testsuites, error) {
+	return Convert(c, ConvertOpt{})
+}
+
+// Convert is FromTAP, configured with opt.
+func Convert(c tap.Case, opt ConvertOpt) (junit.Testsuites, error) {
+	return FromTAPContext(context.Background(), c, opt)
+}
+
+// FromTAPContext is Convert, which stops when ctx is done.  It then returns
+// the error of ctx, and the test cases converted so far, flagged with an
+// "Incomplete" error test case.
+func FromTAPContext(ctx context.Context, c tap.Case, opt ConvertOpt) (junit.Testsuites, error) {
 	var (
-		r      junit.Testsuites
-		s      junit.Suite
-		nt, nf int
-		d      time.Time
+		r               junit.Testsuites
+		s               junit.Suite
+		nt, nf, ne, nsk int
+		d               time.Time
 	)
-	for _, r := range c.Results {
-		var c junit.Case
-		c.ID = strHash(r.Header)
-		c.Name = r.Header
+	cs := testcases(ctx, c, "", opt)
+	err := ctx.Err()
+	if c.BailOut != nil && err == nil {
+		// A bail out in a subtest is also recorded in all of its parents, so
+		// this reports it exactly once.
+		cs = append(cs, bailOut(*c.BailOut, opt))
+	}
+	if c.Incomplete || err != nil {
+		cs = append(cs, incomplete(c))
+	}
+	for _, tc := range cs {
 		nt++
-		if r.Status == tap.FAILED {
-			var f junit.Failure
+		if len(tc.Failures) > 0 {
 			nf++
-			f.Type = "TestFailed"
-			f.Text = r.Raw
-			f.Message = r.Header
-			// Test message - full first line
-			c.Failures = append(c.Failures, f)
 		}
-		d = d.Add(r.Duration)
-		c.Time = junit.DurationSec{r.Duration}
-		s.Testcases = append(s.Testcases, c)
+		if len(tc.Errors) > 0 {
+			ne++
+		}
+		if tc.Skipped != nil {
+			nsk++
+		}
+		d = d.Add(tc.Time.Duration)
+		s.Testcases = append(s.Testcases, tc)
 	}
 	td := d.Sub(time.Time{})
-	r.Time = junit.DurationSec{td}
+	r.Time = junit.DurationSec{Duration: td}
 	r.NumTests = nt
 	r.NumFailures = nf
+	r.NumErrors = ne
+	r.NumSkipped = nsk
 
 	s.Name = c.Name
 	s.ID = strHash(s.Name)
-	s.Time = junit.DurationSec{td}
+	s.Time = junit.DurationSec{Duration: td}
+	s.Timestamp = junit.Timestamp{Time: c.Start}
 	s.NumTests = nt
 	s.NumFailures = nf
+	s.NumErrors = ne
+	s.NumSkipped = nsk
+	if opt.Positions && c.Plan != nil {
+		addSpan(&s.Properties, "tap.plan.", c.Plan.Span())
+	}
+	if c.Output != "" {
+		s.SystemOut = &junit.Output{Text: c.Output}
+	}
 
 	r.Suites = append(r.Suites, s)
+	return r, err
+}
+
+// FromTAPAll converts TAP test cases, such as the documents returned by
+// tap.ReadAll, into one jUnit testsuite each.
+func FromTAPAll(cs []tap.Case, opt ConvertOpt) (junit.Testsuites, error) {
+	return FromTAPAllContext(context.Background(), cs, opt)
+}
+
+// FromTAPAllContext is FromTAPAll, which stops when ctx is done.  It then
+// returns the error of ctx, and the testsuites converted so far, the last one
+// flagged as in FromTAPContext.
+func FromTAPAllContext(ctx context.Context, cs []tap.Case, opt ConvertOpt) (junit.Testsuites, error) {
+	var r junit.Testsuites
+	for _, c := range cs {
+		t, err := FromTAPContext(ctx, c, opt)
+		if err != nil && ctx.Err() == nil {
+			return r, fmt.Errorf("while converting %q: %v", c.Name, err)
+		}
+		r.NumTests += t.NumTests
+		r.NumFailures += t.NumFailures
+		r.NumErrors += t.NumErrors
+		r.NumSkipped += t.NumSkipped
+		r.Time.Duration += t.Time.Duration
+		r.Suites = append(r.Suites, t.Suites...)
+		if err != nil {
+			return r, err
+		}
+	}
 	return r, nil
 }
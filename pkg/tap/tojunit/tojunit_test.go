@@ -1,6 +1,9 @@
 package tojunit
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -13,6 +16,7 @@ func TestConversion(t *testing.T) {
 	tests := []struct {
 		name     string
 		input    tap.Case
+		opt      ConvertOpt
 		expected junit.Testsuites
 	}{
 		{
@@ -22,10 +26,11 @@ func TestConversion(t *testing.T) {
 				Name:    "test_name_here",
 				Results: []tap.Result{
 					{
-						Status:   tap.PASSED,
-						Duration: 2 * time.Second,
-						Raw:      "Some string here",
-						Header:   "Header0",
+						Status:      tap.PASSED,
+						Duration:    2 * time.Second,
+						Raw:         "Some string here",
+						Header:      "Header0",
+						Description: "Header0",
 					},
 					{
 						Status:   tap.FAILED,
@@ -33,11 +38,13 @@ func TestConversion(t *testing.T) {
 						Raw: `not ok 2 Test failed
 # Some failure message
 `,
-						Header: "Header1",
+						Header:      "Header1",
+						Description: "Header1",
 					},
 					{
-						Status: tap.SKIPPED,
-						Header: "Header2",
+						Status:      tap.SKIPPED,
+						Header:      "Header2",
+						Description: "Header2",
 					},
 					{
 						Status: tap.UNKNOWN,
@@ -48,24 +55,28 @@ func TestConversion(t *testing.T) {
 			expected: junit.Testsuites{
 				NumTests:    4,
 				NumFailures: 1,
-				Time:        junit.DurationSec{5 * time.Second},
+				NumErrors:   1,
+				NumSkipped:  1,
+				Time:        junit.DurationSec{Duration: 5 * time.Second},
 				Suites: []junit.Suite{
 					{
 						ID:          strHash("test_name_here"),
 						Name:        "test_name_here",
 						NumTests:    4,
 						NumFailures: 1,
-						Time:        junit.DurationSec{5 * time.Second},
+						NumErrors:   1,
+						NumSkipped:  1,
+						Time:        junit.DurationSec{Duration: 5 * time.Second},
 						Testcases: []junit.Case{
 							{
 								ID:   strHash("Header0"),
 								Name: "Header0",
-								Time: junit.DurationSec{2 * time.Second},
+								Time: junit.DurationSec{Duration: 2 * time.Second},
 							},
 							{
 								ID:   strHash("Header1"),
 								Name: "Header1",
-								Time: junit.DurationSec{3 * time.Second},
+								Time: junit.DurationSec{Duration: 3 * time.Second},
 								Failures: []junit.Failure{
 									{
 										Type:    "TestFailed",
@@ -77,11 +88,510 @@ func TestConversion(t *testing.T) {
 								},
 							},
 							{
-								ID:   strHash("Header2"),
-								Name: "Header2",
+								ID:      strHash("Header2"),
+								Name:    "Header2",
+								Skipped: &junit.Skipped{},
 							},
 							{
-								ID: strHash(""),
+								// Not a passing test without a name.
+								ID:   strHash("test 4 (no result)"),
+								Name: "test 4 (no result)",
+								Errors: []junit.Error{
+									{Type: "MissingTest", Message: "test 4 reported no result"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "YAML diagnostics",
+			input: tap.Case{
+				Name: "yaml",
+				Results: []tap.Result{
+					{
+						Status:      tap.FAILED,
+						Header:      "Addition",
+						Description: "Addition",
+						Raw:         " 1 Addition\n  ---\n  message: sum\n  found: 3\n  ...",
+						YAML:        "message: |\n  sum is\n  wrong\nfound: 3\nwanted: 2",
+						Diagnostics: map[string]any{
+							"message": "sum is\nwrong\n",
+							"found":   "3",
+							"wanted":  "2",
+						},
+					},
+				},
+			},
+			expected: junit.Testsuites{
+				NumTests:    1,
+				NumFailures: 1,
+				Suites: []junit.Suite{
+					{
+						ID:          strHash("yaml"),
+						Name:        "yaml",
+						NumTests:    1,
+						NumFailures: 1,
+						Testcases: []junit.Case{
+							{
+								ID:   strHash("Addition"),
+								Name: "Addition",
+								Failures: []junit.Failure{
+									{
+										Type:    "TestFailed",
+										Message: "sum is\nwrong",
+										Text:    "found: 3\nwanted: 2",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Subtests",
+			input: tap.Case{
+				Name: "subtests",
+				Results: []tap.Result{
+					{
+						Status:      tap.PASSED,
+						Header:      "outer",
+						Description: "outer",
+						Subtest: &tap.Case{
+							Name: "outer",
+							Results: []tap.Result{
+								{
+									Status:      tap.PASSED,
+									Header:      "inner",
+									Description: "inner",
+									Duration:    time.Second,
+								},
+								{
+									Status:      tap.PASSED,
+									Header:      "nested",
+									Description: "nested",
+									Subtest: &tap.Case{
+										Name: "nested",
+										Results: []tap.Result{
+											{Status: tap.PASSED, Header: "deepest", Description: "deepest"},
+										},
+									},
+								},
+							},
+						},
+					},
+					{
+						Status:      tap.FAILED,
+						Header:      "planless",
+						Description: "planless",
+						Raw:         "not ok 2 planless",
+						Subtest: &tap.Case{
+							Name: "planless",
+							Results: []tap.Result{
+								{Status: tap.PASSED, Header: "only", Description: "only"},
+							},
+						},
+					},
+				},
+			},
+			expected: junit.Testsuites{
+				NumTests:    4,
+				NumFailures: 1,
+				Time:        junit.DurationSec{Duration: time.Second},
+				Suites: []junit.Suite{
+					{
+						ID:          strHash("subtests"),
+						Name:        "subtests",
+						NumTests:    4,
+						NumFailures: 1,
+						Time:        junit.DurationSec{Duration: time.Second},
+						Testcases: []junit.Case{
+							{
+								ID:        strHash("outer.inner"),
+								Name:      "inner",
+								Classname: "outer",
+								Time:      junit.DurationSec{Duration: time.Second},
+							},
+							{
+								ID:        strHash("outer.nested.deepest"),
+								Name:      "deepest",
+								Classname: "outer.nested",
+							},
+							{
+								ID:   strHash("planless"),
+								Name: "planless",
+								Failures: []junit.Failure{
+									{
+										Type:    "TestFailed",
+										Message: "planless",
+										Text:    "not ok 2 planless",
+									},
+								},
+							},
+							{
+								ID:        strHash("planless.only"),
+								Name:      "only",
+								Classname: "planless",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Plan violations",
+			input: tap.Case{
+				Name: "plan",
+				Plan: &tap.Plan{First: 1, Last: 2},
+				Results: []tap.Result{
+					{Status: tap.PASSED, Header: "one", Description: "one"},
+					{},
+					{Status: tap.PASSED, Header: "three", Description: "three"},
+				},
+				Missing:    []int{2},
+				Duplicates: []int{1},
+				OutOfPlan:  []int{3},
+			},
+			expected: junit.Testsuites{
+				NumTests:  5,
+				NumErrors: 3,
+				Suites: []junit.Suite{
+					{
+						ID:        strHash("plan"),
+						Name:      "plan",
+						NumTests:  5,
+						NumErrors: 3,
+						Testcases: []junit.Case{
+							{
+								ID:   strHash("one"),
+								Name: "one",
+							},
+							{
+								ID:   strHash("test 2 (missing)"),
+								Name: "test 2 (missing)",
+								Errors: []junit.Error{
+									{
+										Type:    "MissingTest",
+										Message: "test 2 was planned, but reported no result",
+									},
+								},
+							},
+							{
+								ID:   strHash("three"),
+								Name: "three",
+							},
+							{
+								ID:   strHash("test 1 (duplicate)"),
+								Name: "test 1 (duplicate)",
+								Errors: []junit.Error{
+									{
+										Type:    "DuplicateTest",
+										Message: "test 1 reported a result more than once",
+									},
+								},
+							},
+							{
+								ID:   strHash("test 3 (out of plan)"),
+								Name: "test 3 (out of plan)",
+								Errors: []junit.Error{
+									{
+										Type:    "OutOfPlanTest",
+										Message: "test 3 reported a result, but is outside of the plan",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Bail out",
+			input: tap.Case{
+				Name: "bail",
+				Results: []tap.Result{
+					{Status: tap.PASSED, Header: "one", Description: "one"},
+					{},
+				},
+				Missing: []int{2},
+				BailOut: &tap.BailOut{
+					Token:  tap.Token{Line: 3},
+					Reason: "No database.",
+				},
+			},
+			expected: junit.Testsuites{
+				NumTests:  3,
+				NumErrors: 2,
+				Suites: []junit.Suite{
+					{
+						ID:        strHash("bail"),
+						Name:      "bail",
+						NumTests:  3,
+						NumErrors: 2,
+						Testcases: []junit.Case{
+							{
+								ID:   strHash("one"),
+								Name: "one",
+							},
+							{
+								ID:   strHash("test 2 (not run)"),
+								Name: "test 2 (not run)",
+								Errors: []junit.Error{
+									{
+										Type:    "NotRun",
+										Message: "test 2 did not run, because the test run bailed out",
+									},
+								},
+							},
+							{
+								ID:   strHash("Bail out!"),
+								Name: "Bail out!",
+								Errors: []junit.Error{
+									{
+										Type:    "BailOut",
+										Message: "No database.",
+										Text:    "line 3: Bail out! No database.",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Directive mapping",
+			input: tap.Case{
+				Name: "directives",
+				Results: []tap.Result{
+					{
+						Status:          tap.SKIPPED,
+						OK:              true,
+						Description:     "skip",
+						DirectiveReason: "no database",
+					},
+					{
+						Status:          tap.TODO,
+						Description:     "todo",
+						DirectiveReason: "not yet",
+					},
+					{
+						Status:          tap.TODO,
+						OK:              true,
+						Description:     "bonus",
+						DirectiveReason: "not yet",
+					},
+				},
+			},
+			opt: ConvertOpt{
+				Skip:       Skipped,
+				TodoFailed: Skipped,
+				TodoPassed: Failed,
+			},
+			expected: junit.Testsuites{
+				NumTests:    3,
+				NumFailures: 1,
+				NumSkipped:  2,
+				Suites: []junit.Suite{
+					{
+						ID:          strHash("directives"),
+						Name:        "directives",
+						NumTests:    3,
+						NumFailures: 1,
+						NumSkipped:  2,
+						Testcases: []junit.Case{
+							{
+								ID:      strHash("skip"),
+								Name:    "skip",
+								Skipped: &junit.Skipped{Message: "no database"},
+							},
+							{
+								ID:      strHash("todo"),
+								Name:    "todo",
+								Skipped: &junit.Skipped{Message: "not yet"},
+							},
+							{
+								ID:   strHash("bonus"),
+								Name: "bonus",
+								Failures: []junit.Failure{
+									{
+										Type:    "TestFailed",
+										Message: "bonus",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Skip all",
+			input: tap.Case{
+				Name:       "skipped_file",
+				Skipped:    true,
+				SkipReason: "no database",
+			},
+			expected: junit.Testsuites{
+				NumTests:   1,
+				NumSkipped: 1,
+				Suites: []junit.Suite{
+					{
+						ID:         strHash("skipped_file"),
+						Name:       "skipped_file",
+						NumTests:   1,
+						NumSkipped: 1,
+						Testcases: []junit.Case{
+							{
+								ID:      strHash("skipped_file"),
+								Name:    "skipped_file",
+								Skipped: &junit.Skipped{Message: "no database"},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Suite output",
+			input: tap.Case{
+				Name:   "output",
+				Output: "# starting suite",
+				Results: []tap.Result{
+					{Status: tap.PASSED, Description: "one"},
+				},
+			},
+			expected: junit.Testsuites{
+				NumTests: 1,
+				Suites: []junit.Suite{
+					{
+						ID:       strHash("output"),
+						Name:     "output",
+						NumTests: 1,
+						Testcases: []junit.Case{
+							{
+								ID:   strHash("one"),
+								Name: "one",
+							},
+						},
+						SystemOut: &junit.Output{Text: "# starting suite"},
+					},
+				},
+			},
+		},
+		{
+			name: "Annotations",
+			input: tap.Case{
+				Name: "annotated",
+				Results: []tap.Result{
+					{
+						Status:      tap.PASSED,
+						Description: "one",
+						Output:      "some output",
+						Classname:   "suite.Things",
+						File:        "things.sh",
+						Line:        12,
+						Properties:  []tap.Property{{Name: "owner", Value: "alice"}},
+						Start:       time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+						Attachments: []string{"one.log", "one.png"},
+					},
+				},
+			},
+			expected: junit.Testsuites{
+				NumTests: 1,
+				Suites: []junit.Suite{
+					{
+						ID:       strHash("annotated"),
+						Name:     "annotated",
+						NumTests: 1,
+						Testcases: []junit.Case{
+							{
+								ID:        strHash("suite.Things.one"),
+								Name:      "one",
+								Classname: "suite.Things",
+								File:      "things.sh",
+								Line:      12,
+								Timestamp: junit.Timestamp{Time: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)},
+								Properties: &junit.Properties{
+									Properties: []junit.Property{{Name: "owner", Value: "alice"}},
+								},
+								SystemOut: &junit.Output{
+									Text: "some output\n[[ATTACHMENT|one.log]]\n[[ATTACHMENT|one.png]]",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Positions",
+			input: tap.Case{
+				Name: "positions",
+				Plan: &tap.Plan{Token: tap.Token{Line: 1, EndLine: 1, EndOffset: 5}, First: 1, Last: 1},
+				Results: []tap.Result{
+					{
+						Status:      tap.PASSED,
+						Description: "one",
+						Span:        tap.Span{Line: 2, EndLine: 4, Offset: 5, EndOffset: 40},
+					},
+				},
+				BailOut: &tap.BailOut{
+					Token:  tap.Token{Line: 5, EndLine: 5, Offset: 40, EndOffset: 56},
+					Reason: "Stop.",
+				},
+			},
+			opt: ConvertOpt{Positions: true},
+			expected: junit.Testsuites{
+				NumTests:  2,
+				NumErrors: 1,
+				Suites: []junit.Suite{
+					{
+						ID:        strHash("positions"),
+						Name:      "positions",
+						NumTests:  2,
+						NumErrors: 1,
+						Properties: &junit.Properties{
+							Properties: []junit.Property{
+								{Name: "tap.plan.line", Value: "1"},
+								{Name: "tap.plan.end_line", Value: "1"},
+								{Name: "tap.plan.offset", Value: "0"},
+								{Name: "tap.plan.end_offset", Value: "5"},
+							},
+						},
+						Testcases: []junit.Case{
+							{
+								ID:   strHash("one"),
+								Name: "one",
+								Properties: &junit.Properties{
+									Properties: []junit.Property{
+										{Name: "tap.line", Value: "2"},
+										{Name: "tap.end_line", Value: "4"},
+										{Name: "tap.offset", Value: "5"},
+										{Name: "tap.end_offset", Value: "40"},
+									},
+								},
+							},
+							{
+								ID:   strHash("Bail out!"),
+								Name: "Bail out!",
+								Properties: &junit.Properties{
+									Properties: []junit.Property{
+										{Name: "tap.line", Value: "5"},
+										{Name: "tap.end_line", Value: "5"},
+										{Name: "tap.offset", Value: "40"},
+										{Name: "tap.end_offset", Value: "56"},
+									},
+								},
+								Errors: []junit.Error{
+									{
+										Type:    "BailOut",
+										Message: "Stop.",
+										Text:    "line 5: Bail out! Stop.",
+									},
+								},
 							},
 						},
 					},
@@ -93,7 +603,7 @@ func TestConversion(t *testing.T) {
 		test := test
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
-			actual, err := FromTAP(test.input)
+			actual, err := Convert(test.input, test.opt)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -104,3 +614,108 @@ func TestConversion(t *testing.T) {
 		})
 	}
 }
+
+func TestConversionAll(t *testing.T) {
+	input := []tap.Case{
+		{
+			Name: "first",
+			Results: []tap.Result{
+				{Status: tap.PASSED, Duration: time.Second, Description: "one"},
+			},
+		},
+		{
+			Name: "second",
+			Results: []tap.Result{
+				{Status: tap.SKIPPED, Description: "two"},
+				{Status: tap.FAILED, Duration: 2 * time.Second, Description: "three"},
+			},
+		},
+	}
+	actual, err := FromTAPAll(input, ConvertOpt{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, s := range actual.Suites {
+		names = append(names, s.Name)
+	}
+	if expected := []string{"first", "second"}; !cmp.Equal(expected, names) {
+		t.Errorf("suites diff:\n%v", cmp.Diff(expected, names))
+	}
+	expected := []int{3, 1, 1}
+	totals := []int{actual.NumTests, actual.NumFailures, actual.NumSkipped}
+	if !cmp.Equal(expected, totals) {
+		t.Errorf("totals diff:\n%v", cmp.Diff(expected, totals))
+	}
+	if d := actual.Time.Duration; d != 3*time.Second {
+		t.Errorf("got time %v, expected 3s", d)
+	}
+}
+
+func TestConversionContext(t *testing.T) {
+	input := tap.Case{
+		Name:       "incomplete",
+		Incomplete: true,
+		Results:    []tap.Result{{Status: tap.PASSED, Description: "one"}},
+	}
+	actual, err := FromTAP(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := func(r junit.Testsuites) []string {
+		var ns []string
+		for _, s := range r.Suites {
+			for _, c := range s.Testcases {
+				ns = append(ns, c.Name)
+			}
+		}
+		return ns
+	}
+	if expected := []string{"one", "Incomplete"}; !cmp.Equal(expected, names(actual)) {
+		t.Errorf("testcases diff:\n%v", cmp.Diff(expected, names(actual)))
+	}
+	if actual.NumErrors != 1 {
+		t.Errorf("got %d errors, expected 1 for the incomplete report", actual.NumErrors)
+	}
+
+	// A conversion that is cancelled is incomplete too.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	input.Incomplete = false
+	actual, err = FromTAPAllContext(ctx, []tap.Case{input, input}, ConvertOpt{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+	if expected := []string{"Incomplete"}; !cmp.Equal(expected, names(actual)) {
+		t.Errorf("testcases diff:\n%v", cmp.Diff(expected, names(actual)))
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	for _, o := range []Outcome{Skipped, Passed, Failed} {
+		b, err := o.MarshalText()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(b) != o.String() {
+			t.Errorf("got text %q, expected %q", b, o.String())
+		}
+		var actual Outcome
+		if err := actual.Set(string(b)); err != nil || actual != o {
+			t.Errorf("got %v (error %v), expected %v", actual, err, o)
+		}
+	}
+	if s := fmt.Sprint(Passed); s != "passed" {
+		t.Errorf("got %q, expected passed", s)
+	}
+	if s := Outcome(42).String(); s != "Outcome(42)" {
+		t.Errorf("got %q, expected Outcome(42)", s)
+	}
+	if _, err := Outcome(42).MarshalText(); err == nil {
+		t.Errorf("expected an error for an unknown outcome")
+	}
+	var o Outcome
+	if err := o.Set("ignored"); err == nil {
+		t.Errorf("expected an error for an unknown outcome, got %v", o)
+	}
+}
@@ -0,0 +1,206 @@
+package tap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteOpt is the set of options passed to configure the writer.
+type WriteOpt struct {
+	// Version is the TAP version written, 13 or 14.  If 0, it is the version
+	// of the Case, and at least 13.
+	Version int
+	// Output writes the output of the tests, the lines that are not TAP, as
+	// comments.  Otherwise it is dropped.
+	Output bool
+	// Annotations writes the durations, and the other fields set by
+	// directives, as "# TAP2JUNIT:" annotations.
+	Annotations bool
+}
+
+// Write writes c to w as a TAP stream: its version, plan, test points with
+// their directives and YAML diagnostics, subtests and bail out.  Reading the
+// stream back gives c, except for what TAP has no place for: the raw input,
+// the warnings, the comments and the pragmas.  It is written as UTF-8, with
+// "\n" line endings, whatever the Encoding of c.
+func Write(c Case, w io.Writer, opt WriteOpt) error {
+	v := opt.Version
+	if v == 0 {
+		v = max(c.Version, 13)
+	}
+	if v != 13 && v != 14 {
+		return fmt.Errorf("cannot write TAP version %d, want 13 or 14", v)
+	}
+	tw := &tapWriter{w: bufio.NewWriter(w), opt: opt}
+	tw.line(0, fmt.Sprintf("TAP version %d", v))
+	tw.write(c, 0)
+	if c.BailOut != nil {
+		tw.line(0, strings.TrimSpace("Bail out! "+c.BailOut.Reason))
+	}
+	return tw.w.Flush()
+}
+
+// tapWriter writes a Case.  Its bufio.Writer keeps the first write error,
+// which Flush returns.
+type tapWriter struct {
+	w   *bufio.Writer
+	opt WriteOpt
+}
+
+// line writes the line l at the subtest depth d.
+func (tw *tapWriter) line(d int, l string) {
+	for i := 0; i < d; i++ {
+		tw.w.WriteString(subtestIndent)
+	}
+	tw.w.WriteString(l)
+	tw.w.WriteByte('\n')
+}
+
+// comments writes the text s, if any, as comment lines.
+func (tw *tapWriter) comments(d int, s string) {
+	if s == "" {
+		return
+	}
+	for _, l := range strings.Split(s, "\n") {
+		if !strings.HasPrefix(l, "#") {
+			l = "# " + l
+		}
+		tw.line(d, l)
+	}
+}
+
+// write writes the plan and the results of c at the subtest depth d.
+func (tw *tapWriter) write(c Case, d int) {
+	if tw.opt.Output {
+		tw.comments(d, c.Output)
+	}
+	missing := map[int]bool{}
+	for _, n := range c.Missing {
+		missing[n] = true
+	}
+	switch {
+	case c.Skipped:
+		tw.line(d, strings.TrimSpace("1..0 # SKIP "+c.SkipReason))
+	case c.First != nil && c.Last != nil:
+		tw.line(d, fmt.Sprintf("%d..%d", *c.First, planLast(c, missing)))
+	}
+	for i, r := range c.Results {
+		n := i + 1
+		if missing[n] {
+			continue
+		}
+		if r.Subtest != nil {
+			if r.Subtest.Name != "" {
+				tw.line(d+1, "# Subtest: "+r.Subtest.Name)
+			}
+			tw.write(*r.Subtest, d+1)
+		}
+		// A result without a test point is planned but never reported, or a
+		// subtest that was not summarized.
+		if r.Status != UNKNOWN {
+			tw.testPoint(d, n, r)
+		}
+	}
+}
+
+// planLast returns the last test of the plan of c, which is not c.Last if
+// tests past the plan reported results.
+func planLast(c Case, missing map[int]bool) int {
+	first, last := *c.First, *c.Last
+	for _, n := range c.OutOfPlan {
+		if n > first && n <= last {
+			last = n - 1
+		}
+	}
+	// The tests between the plan and the first test past it did not report.
+	for last > first && last < *c.Last && last <= len(c.Results) && !missing[last] && c.Results[last-1].Status == UNKNOWN {
+		last--
+	}
+	return last
+}
+
+// testPoint writes the test point of the result r, numbered n.
+func (tw *tapWriter) testPoint(d, n int, r Result) {
+	var b strings.Builder
+	if !r.OK {
+		b.WriteString("not ")
+	}
+	fmt.Fprintf(&b, "ok %d", n)
+	if r.Description != "" {
+		b.WriteString(" - ")
+		b.WriteString(escape(r.Description))
+	}
+	if r.Directive != "" {
+		b.WriteString(" # ")
+		b.WriteString(r.Directive)
+		if r.DirectiveReason != "" {
+			b.WriteString(" ")
+			b.WriteString(escape(r.DirectiveReason))
+		}
+	}
+	tw.line(d, b.String())
+	if r.YAML != "" {
+		tw.line(d, "  ---")
+		for _, l := range strings.Split(strings.TrimSuffix(r.YAML, "\n"), "\n") {
+			if l == "" {
+				tw.line(0, "")
+				continue
+			}
+			tw.line(d, "  "+l)
+		}
+		tw.line(d, "  ...")
+	}
+	if tw.opt.Annotations {
+		for _, a := range annotations(r) {
+			tw.line(d, "# TAP2JUNIT: "+a)
+		}
+	}
+	if tw.opt.Output {
+		tw.comments(d, r.Output)
+	}
+}
+
+// annotations returns the "# TAP2JUNIT:" annotations that give the fields of
+// r set by directives, without the prefix.
+func annotations(r Result) []string {
+	var as []string
+	add := func(name, v string) {
+		if v != "" {
+			as = append(as, name+": "+v)
+		}
+	}
+	add("Classname", r.Classname)
+	add("File", r.File)
+	if r.Line > 0 {
+		add("Line", fmt.Sprint(r.Line))
+	}
+	for _, p := range r.Properties {
+		add("Property", p.Name+"="+p.Value)
+	}
+	for _, a := range r.Attachments {
+		add("Attachment", a)
+	}
+	if !r.Start.IsZero() {
+		add("Start", r.Start.Format(time.RFC3339Nano))
+	}
+	if !r.End.IsZero() {
+		add("End", r.End.Format(time.RFC3339Nano))
+	}
+	// Last, as the timestamps would set it.
+	if r.Duration != 0 {
+		add("Duration", r.Duration.String())
+	}
+	return as
+}
+
+// escape escapes "\" and "#" in a test description or a directive reason, as
+// TAP 14 does.
+func escape(s string) string {
+	if !strings.ContainsAny(s, `\#`) {
+		return s
+	}
+	return strings.NewReplacer(`\`, `\\`, "#", `\#`).Replace(s)
+}
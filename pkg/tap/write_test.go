@@ -0,0 +1,134 @@
+package tap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestWrite(t *testing.T) {
+	first, last := 1, 4
+	c := Case{
+		Version: 14,
+		First:   &first,
+		Last:    &last,
+		Results: []Result{
+			{Status: PASSED, OK: true, Description: "issue #42 and a \\", Duration: time.Second},
+			{
+				Status:      FAILED,
+				Description: "broken",
+				YAML:        "message: no\nat:\n  line: 3\n",
+				Output:      "some output",
+			},
+			{
+				Status:      PASSED,
+				OK:          true,
+				Description: "sub",
+				Subtest: &Case{
+					Name:    "sub",
+					First:   &first,
+					Last:    &first,
+					Results: []Result{{Status: SKIPPED, OK: true, Directive: "SKIP", DirectiveReason: "no #db"}},
+				},
+			},
+			{},
+		},
+		Missing: []int{4},
+		BailOut: &BailOut{Reason: "Enough."},
+	}
+	var b strings.Builder
+	if err := Write(c, &b, WriteOpt{Output: true, Annotations: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `TAP version 14
+1..4
+ok 1 - issue \#42 and a \\
+# TAP2JUNIT: Duration: 1s
+not ok 2 - broken
+  ---
+  message: no
+  at:
+    line: 3
+  ...
+# some output
+    # Subtest: sub
+    1..1
+    ok 1 # SKIP no \#db
+ok 3 - sub
+Bail out! Enough.
+`
+	if b.String() != expected {
+		t.Errorf("diff:\n%v", cmp.Diff(expected, b.String()))
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	input := `TAP version 13
+1..5
+ok 1 - first with a \# hash
+# TAP2JUNIT: Duration: 1.5s
+# TAP2JUNIT: Property: owner=alice
+not ok 2 - second
+  ---
+  message: failed
+  data:
+    - one
+
+    - two
+  ...
+ok 3 # SKIP no database
+# Subtest: sub
+    1..2
+    ok 1 - inner
+    not ok 2 - inner failure # TODO later
+      ---
+      at: here
+      ...
+    # Subtest: deeper
+        ok 1
+    ok 3 - deeper
+not ok 4 - sub
+Bail out! Crashed.
+`
+	c, err := Read(strings.NewReader(input), ReadOpt{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var b strings.Builder
+	if err := Write(c, &b, WriteOpt{Annotations: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actual, err := Read(strings.NewReader(b.String()), ReadOpt{})
+	if err != nil {
+		t.Fatalf("unexpected error reading back:\n%v\n%v", b.String(), err)
+	}
+	opts := cmp.Options{
+		cmpopts.IgnoreFields(Case{}, "Raw", "Output", "Warnings", "BailOut", "Plan", "Comments"),
+		cmpopts.IgnoreFields(Result{}, "Raw", "Header", "Output", "Span"),
+	}
+	if !cmp.Equal(c, actual, opts) {
+		t.Errorf("written:\n%v\ndiff:\n%v", b.String(), cmp.Diff(c, actual, opts))
+	}
+	if actual.BailOut == nil || actual.BailOut.Reason != "Crashed." {
+		t.Errorf("got bail out %+v, expected one for %q", actual.BailOut, "Crashed.")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestWriteErrors(t *testing.T) {
+	if err := Write(Case{}, failingWriter{}, WriteOpt{}); err == nil {
+		t.Errorf("expected the write error")
+	}
+	if err := Write(Case{}, &strings.Builder{}, WriteOpt{Version: 12}); err == nil {
+		t.Errorf("expected an error for TAP version 12")
+	}
+}
@@ -0,0 +1,260 @@
+package tap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a single line of a YAML diagnostic block.
+type yamlLine struct {
+	// indent is the number of leading spaces.
+	indent int
+	// text is the line content with the indentation removed.
+	text string
+	// num is the line number within the block, for error reporting.
+	num int
+}
+
+// blank returns true if the line carries no YAML structure.
+func (l yamlLine) blank() bool {
+	return l.text == "" || strings.HasPrefix(l.text, "#")
+}
+
+// yamlError is a problem with a YAML diagnostic block.
+type yamlError struct {
+	// line is the line number within the block, starting at 1.
+	line   int
+	reason string
+}
+
+// Error implements error.
+func (e *yamlError) Error() string {
+	return fmt.Sprintf("yaml: line %d: %s", e.line, e.reason)
+}
+
+// yamlParser parses the subset of YAML that TAP producers emit in their
+// diagnostic blocks: block mappings, block sequences, plain and quoted
+// scalars, and literal or folded block scalars.  Scalars are kept as strings,
+// as TAP does not assign any meaning to YAML types.
+type yamlParser struct {
+	lines []yamlLine
+	// i is the index of the next line to consume.
+	i int
+}
+
+// parseYAML parses the YAML diagnostic block s, which must be a mapping.
+func parseYAML(s string) (map[string]any, error) {
+	var p yamlParser
+	for n, l := range strings.Split(s, "\n") {
+		t := strings.TrimLeft(l, " ")
+		p.lines = append(p.lines, yamlLine{
+			indent: len(l) - len(t),
+			text:   strings.TrimRight(t, " \t"),
+			num:    n + 1,
+		})
+	}
+	p.skipBlank()
+	if p.done() {
+		return map[string]any{}, nil
+	}
+	v, err := p.node(p.lines[p.i].indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if !p.done() {
+		return nil, p.errorf("unexpected content")
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, &yamlError{line: 1, reason: "diagnostic block is not a mapping"}
+	}
+	return m, nil
+}
+
+func (p *yamlParser) done() bool {
+	return p.i >= len(p.lines)
+}
+
+func (p *yamlParser) skipBlank() {
+	for !p.done() && p.lines[p.i].blank() {
+		p.i++
+	}
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	n := len(p.lines)
+	if !p.done() {
+		n = p.lines[p.i].num
+	}
+	return &yamlError{line: n, reason: fmt.Sprintf(format, args...)}
+}
+
+func isSeqItem(t string) bool {
+	return t == "-" || strings.HasPrefix(t, "- ")
+}
+
+// node parses a mapping or a sequence whose entries are at indent.
+func (p *yamlParser) node(indent int) (any, error) {
+	if isSeqItem(p.lines[p.i].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+// splitKey splits a "key: value" mapping entry.
+func splitKey(t string) (key, rest string, ok bool) {
+	if strings.HasSuffix(t, ":") {
+		return unquote(t[:len(t)-1]), "", true
+	}
+	if i := strings.Index(t, ": "); i > 0 {
+		return unquote(t[:i]), strings.TrimSpace(t[i+2:]), true
+	}
+	return "", "", false
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.skipBlank(); !p.done(); p.skipBlank() {
+		l := p.lines[p.i]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		if isSeqItem(l.text) {
+			break
+		}
+		k, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, p.errorf("expected a mapping entry, got: %q", l.text)
+		}
+		p.i++
+		v, err := p.value(indent, rest)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	s := []any{}
+	for p.skipBlank(); !p.done(); p.skipBlank() {
+		l := p.lines[p.i]
+		if l.indent != indent || !isSeqItem(l.text) {
+			break
+		}
+		item := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if _, _, ok := splitKey(item); ok && !strings.HasPrefix(item, `"`) && !strings.HasPrefix(item, "'") {
+			// A mapping that starts on the same line as the item marker.
+			// Re-read the line as the first entry of a nested mapping.
+			p.lines[p.i] = yamlLine{
+				indent: indent + len(l.text) - len(item),
+				text:   item,
+				num:    l.num,
+			}
+			v, err := p.mapping(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, v)
+			continue
+		}
+		p.i++
+		v, err := p.value(indent, item)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, v)
+	}
+	return s, nil
+}
+
+// value parses the value of an entry at indent, where rest is the text that
+// followed the key or the sequence item marker on the same line.
+func (p *yamlParser) value(indent int, rest string) (any, error) {
+	switch {
+	case rest == "":
+		p.skipBlank()
+		if p.done() {
+			return "", nil
+		}
+		l := p.lines[p.i]
+		if l.indent > indent || (l.indent == indent && isSeqItem(l.text)) {
+			return p.node(l.indent)
+		}
+		return "", nil
+	case strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">"):
+		return p.blockScalar(indent, rest), nil
+	}
+	return scalar(rest), nil
+}
+
+// blockScalar reads the lines of a literal ("|") or folded (">") scalar that
+// are indented more than indent.
+func (p *yamlParser) blockScalar(indent int, header string) string {
+	var (
+		lines []string
+		bi    = -1
+	)
+	for ; !p.done(); p.i++ {
+		l := p.lines[p.i]
+		if l.text != "" && l.indent <= indent {
+			break
+		}
+		if l.text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if bi < 0 {
+			bi = l.indent
+		}
+		lines = append(lines, strings.Repeat(" ", max(l.indent-bi, 0))+l.text)
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	sep := "\n"
+	if strings.HasPrefix(header, ">") {
+		sep = " "
+	}
+	s := strings.Join(lines, sep)
+	if !strings.HasSuffix(header, "-") && s != "" {
+		s += "\n"
+	}
+	return s
+}
+
+// scalar converts an inline YAML scalar.
+func scalar(s string) any {
+	switch s {
+	case "[]":
+		return []any{}
+	case "{}":
+		return map[string]any{}
+	case "~", "null":
+		return ""
+	}
+	return unquote(s)
+}
+
+// unquote removes YAML quoting from s, if any.
+func unquote(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	switch {
+	case s[0] == '"' && s[len(s)-1] == '"':
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+		return s[1 : len(s)-1]
+	case s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
@@ -0,0 +1,113 @@
+package tap
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected map[string]any
+	}{
+		{
+			name:     "Empty",
+			input:    "",
+			expected: map[string]any{},
+		},
+		{
+			name: "Scalars",
+			input: `message: "quoted \"text\""
+severity: fail
+single: 'it''s'
+empty:
+null: ~
+# A comment
+list: []`,
+			expected: map[string]any{
+				"message":  `quoted "text"`,
+				"severity": "fail",
+				"single":   "it's",
+				"empty":    "",
+				"null":     "",
+				"list":     []any{},
+			},
+		},
+		{
+			name: "Nested",
+			input: `at:
+  file: a.js
+  line: 3
+stack:
+  - one
+  - two
+items:
+- name: first
+  value: 1
+- name: second`,
+			expected: map[string]any{
+				"at": map[string]any{
+					"file": "a.js",
+					"line": "3",
+				},
+				"stack": []any{"one", "two"},
+				"items": []any{
+					map[string]any{"name": "first", "value": "1"},
+					map[string]any{"name": "second"},
+				},
+			},
+		},
+		{
+			name: "Block scalars",
+			input: `literal: |
+  line one
+    indented
+
+  line three
+folded: >-
+  folded
+  text
+after: x`,
+			expected: map[string]any{
+				"literal": "line one\n  indented\n\nline three\n",
+				"folded":  "folded text",
+				"after":   "x",
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			actual, err := parseYAML(test.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cmp.Equal(test.expected, actual) {
+				t.Errorf("diff:\n%v", cmp.Diff(test.expected, actual))
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "Not a mapping", input: "- one\n- two"},
+		{name: "Bad indentation", input: "a: b\n    c: d"},
+		{name: "Not an entry", input: "just text"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := parseYAML(test.input); err == nil {
+				t.Errorf("expected an error for: %q", test.input)
+			}
+		})
+	}
+}